@@ -1,15 +1,94 @@
 package config
 
 import (
+	"fmt"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"claude-cli-as-openai-api/internal/claude"
+	"claude-cli-as-openai-api/internal/workspace"
 )
 
 type Config struct {
 	Port       string
 	ClaudePath string
+
+	// AllowLocalFiles lets requests reference server files via file:// URLs
+	AllowLocalFiles bool
+
+	// AllowRemoteURLs lets requests reference images and files by http(s)
+	// URL, which the server downloads from public addresses
+	AllowRemoteURLs bool
+
+	// ResponseStoreSize is how many Responses API objects are kept for
+	// retrieval and previous_response_id
+	ResponseStoreSize int
+
+	// Models is the catalog of model aliases, loaded from MODELS_FILE
+	Models *Catalog
+
+	// SessionResume enables resuming CLI sessions for chat requests that
+	// extend a known conversation, instead of replaying its history
+	SessionResume    bool
+	SessionCacheSize int
+	SessionTTL       time.Duration
+
+	// MaxConcurrency bounds the CLI processes running at once, and
+	// MaxChoices the n a single request may ask for
+	MaxConcurrency int
+	MaxChoices     int
+
+	// RequestTimeout is how long a request may run unless it asks for
+	// another timeout, up to MaxRequestTimeout. KillGrace is how long a
+	// stopped CLI process may take to exit before it is killed.
+	RequestTimeout    time.Duration
+	MaxRequestTimeout time.Duration
+	KillGrace         time.Duration
+
+	// Retry controls how CLI runs that fail before producing output are
+	// retried
+	Retry claude.RetryPolicy
+
+	// QueueSize bounds the requests waiting for a CLI process, and
+	// QueuePerKey those of a single client
+	QueueSize   int
+	QueuePerKey int
+
+	// ResponseFormatRetries is how many times the model is asked to
+	// correct a reply that doesn't match the requested response_format
+	ResponseFormatRetries int
+
+	// APIKeys are the keys clients must authenticate with, loaded from
+	// API_KEYS_FILE. Nil leaves the API open.
+	APIKeys *KeySet
+
+	// WorkspaceMode selects how CLI runs are isolated: each conversation
+	// gets its own directory under WorkspaceRoot, kept for WorkspaceTTL
+	// after its last use. WorkspaceDirs are the project directories a
+	// request may choose to run in instead.
+	WorkspaceMode workspace.Mode
+	WorkspaceRoot string
+	WorkspaceTTL  time.Duration
+	WorkspaceDirs []string
+
+	// ReplaceSystemPrompt passes system messages to the CLI's
+	// --system-prompt, replacing its default system prompt, instead of
+	// appending them with --append-system-prompt
+	ReplaceSystemPrompt bool
+
+	// Pool keeps warm CLI processes to cut the startup time of requests.
+	// It is disabled unless POOL_SIZE is set.
+	Pool claude.PoolConfig
+
+	// ToolProgress streams the CLI's web searches and fetches to chat
+	// clients as "progress" events
+	ToolProgress bool
 }
 
-func Load() *Config {
+func Load() (*Config, error) {
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8080"
@@ -20,8 +99,136 @@ func Load() *Config {
 		claudePath = "claude"
 	}
 
+	models := DefaultCatalog()
+	if path := os.Getenv("MODELS_FILE"); path != "" {
+		var err error
+		if models, err = LoadCatalog(path); err != nil {
+			return nil, err
+		}
+	}
+
+	var keys *KeySet
+	if path := os.Getenv("API_KEYS_FILE"); path != "" {
+		var err error
+		if keys, err = LoadKeys(path); err != nil {
+			return nil, err
+		}
+	}
+
+	workspaceMode, err := workspace.ParseMode(envString("WORKSPACE_MODE", string(workspace.ModeSession)))
+	if err != nil {
+		return nil, err
+	}
+	replaceSystemPrompt := false
+	switch mode := envString("SYSTEM_PROMPT_MODE", "append"); mode {
+	case "append":
+	case "replace":
+		replaceSystemPrompt = true
+	default:
+		return nil, fmt.Errorf("invalid SYSTEM_PROMPT_MODE %q: must be append or replace", mode)
+	}
+
+	var retryKinds []claude.ErrorKind
+	for _, name := range strings.Split(envString("RETRY_ON", "overloaded,network,crash"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			kind, err := claude.ParseErrorKind(name)
+			if err != nil {
+				return nil, fmt.Errorf("invalid RETRY_ON: %w", err)
+			}
+			retryKinds = append(retryKinds, kind)
+		}
+	}
+
+	retry := claude.RetryPolicy{
+		MaxRetries: envCount("RETRY_MAX", 2),
+		BaseDelay:  envDuration("RETRY_BASE_DELAY", 500*time.Millisecond),
+		MaxDelay:   envDuration("RETRY_MAX_DELAY", 5*time.Second),
+		Kinds:      retryKinds,
+	}
+
+	var workspaceDirs []string
+	for _, dir := range strings.Split(os.Getenv("WORKSPACE_ALLOWED_DIRS"), ",") {
+		if dir = strings.TrimSpace(dir); dir != "" {
+			if !filepath.IsAbs(dir) {
+				return nil, fmt.Errorf("WORKSPACE_ALLOWED_DIRS entry %q must be an absolute path", dir)
+			}
+			workspaceDirs = append(workspaceDirs, filepath.Clean(dir))
+		}
+	}
+
+	// Every choice takes a process of its own, so n can't be more than
+	// may run at once
+	maxConcurrency := envInt("MAX_CONCURRENCY", 8)
+	maxChoices := envInt("MAX_CHOICES", min(4, maxConcurrency))
+	if maxChoices > maxConcurrency {
+		return nil, fmt.Errorf("MAX_CHOICES (%d) must not exceed MAX_CONCURRENCY (%d)", maxChoices, maxConcurrency)
+	}
+
 	return &Config{
-		Port:       port,
-		ClaudePath: claudePath,
+		Port:                  port,
+		ClaudePath:            claudePath,
+		AllowLocalFiles:       os.Getenv("ALLOW_LOCAL_FILES") == "true",
+		AllowRemoteURLs:       os.Getenv("ALLOW_REMOTE_URLS") == "true",
+		ResponseStoreSize:     envInt("RESPONSE_STORE_SIZE", 1000),
+		Models:                models,
+		SessionResume:         os.Getenv("SESSION_RESUME") != "false",
+		SessionCacheSize:      envInt("SESSION_CACHE_SIZE", 1000),
+		SessionTTL:            envDuration("SESSION_TTL", time.Hour),
+		MaxConcurrency:        maxConcurrency,
+		MaxChoices:            maxChoices,
+		QueueSize:             envCount("QUEUE_SIZE", 64),
+		RequestTimeout:        envDuration("REQUEST_TIMEOUT", 10*time.Minute),
+		MaxRequestTimeout:     envDuration("MAX_REQUEST_TIMEOUT", time.Hour),
+		KillGrace:             envDuration("KILL_GRACE", 5*time.Second),
+		Retry:                 retry,
+		QueuePerKey:           envInt("QUEUE_PER_KEY", 16),
+		ResponseFormatRetries: envCount("RESPONSE_FORMAT_RETRIES", 2),
+		APIKeys:               keys,
+		WorkspaceMode:         workspaceMode,
+		WorkspaceRoot:         envString("WORKSPACE_ROOT", filepath.Join(os.TempDir(), "claude-workspaces")),
+		WorkspaceTTL:          envDuration("WORKSPACE_TTL", time.Hour),
+		WorkspaceDirs:         workspaceDirs,
+		ReplaceSystemPrompt:   replaceSystemPrompt,
+		ToolProgress:          os.Getenv("TOOL_PROGRESS") == "true",
+		Pool: claude.PoolConfig{
+			Size:         envCount("POOL_SIZE", 0),
+			IdleTimeout:  envDuration("POOL_IDLE_TIMEOUT", 10*time.Minute),
+			MaxRequests:  envCount("POOL_MAX_REQUESTS", 1),
+			ReapInterval: envDuration("POOL_REAP_INTERVAL", 30*time.Second),
+		},
+	}, nil
+}
+
+// envString reads a string from the environment, falling back to def
+func envString(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// envInt reads a positive integer from the environment, falling back to def
+func envInt(key string, def int) int {
+	if v, err := strconv.Atoi(os.Getenv(key)); err == nil && v > 0 {
+		return v
+	}
+	return def
+}
+
+// envCount reads a non-negative integer from the environment, falling back
+// to def
+func envCount(key string, def int) int {
+	if v, err := strconv.Atoi(os.Getenv(key)); err == nil && v >= 0 {
+		return v
+	}
+	return def
+}
+
+// envDuration reads a positive duration such as "30m" from the
+// environment, falling back to def
+func envDuration(key string, def time.Duration) time.Duration {
+	if v, err := time.ParseDuration(os.Getenv(key)); err == nil && v > 0 {
+		return v
 	}
+	return def
 }
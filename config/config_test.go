@@ -0,0 +1,43 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLoadMaxChoices(t *testing.T) {
+	tests := []struct {
+		name        string
+		concurrency string
+		choices     string
+		want        int
+		wantErr     string
+	}{
+		{"defaults", "", "", 4, ""},
+		{"default follows a lower concurrency", "2", "", 2, ""},
+		{"explicit", "8", "6", 6, ""},
+		{"explicit above concurrency", "2", "3", 0, "must not exceed MAX_CONCURRENCY"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("MODELS_FILE", "")
+			t.Setenv("API_KEYS_FILE", "")
+			t.Setenv("MAX_CONCURRENCY", tt.concurrency)
+			t.Setenv("MAX_CHOICES", tt.choices)
+
+			cfg, err := Load()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Load() = %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Load() = %v", err)
+			}
+			if cfg.MaxChoices != tt.want {
+				t.Errorf("MaxChoices = %d, want %d", cfg.MaxChoices, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// APIKey is a client credential and the tool policy it is limited to
+type APIKey struct {
+	Key  string `json:"key"`
+	Name string `json:"name"`
+
+	// Tools caps the tools of every request made with the key. Nil
+	// leaves the models' policies as they are.
+	Tools *ToolPolicy `json:"tools,omitempty"`
+
+	// Projects are the directories, and the directories inside them,
+	// the key's requests may run in with X-Claude-Workdir. They must be
+	// allowed by WORKSPACE_ALLOWED_DIRS too.
+	Projects []string `json:"projects,omitempty"`
+}
+
+// KeySet is the set of API keys accepted by the server
+type KeySet struct {
+	Keys []APIKey `json:"keys"`
+
+	byKey map[string]*APIKey
+}
+
+// LoadKeys reads API keys from a JSON file
+func LoadKeys(path string) (*KeySet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read API keys file: %w", err)
+	}
+
+	var keys KeySet
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("failed to parse API keys file: %w", err)
+	}
+	if len(keys.Keys) == 0 {
+		return nil, fmt.Errorf("API keys file defines no keys")
+	}
+	return NewKeySet(keys.Keys...)
+}
+
+// NewKeySet validates a set of API keys
+func NewKeySet(keys ...APIKey) (*KeySet, error) {
+	set := &KeySet{Keys: keys, byKey: make(map[string]*APIKey, len(keys))}
+	for i := range set.Keys {
+		k := &set.Keys[i]
+		if k.Key == "" {
+			return nil, fmt.Errorf("API key %d is empty", i)
+		}
+		if _, dup := set.byKey[k.Key]; dup {
+			return nil, fmt.Errorf("duplicate API key %q", k.Name)
+		}
+		if k.Tools != nil {
+			if err := k.Tools.validate(); err != nil {
+				return nil, fmt.Errorf("API key %q: %w", k.Name, err)
+			}
+		}
+		for j, dir := range k.Projects {
+			if !filepath.IsAbs(dir) {
+				return nil, fmt.Errorf("API key %q: project %q must be an absolute path", k.Name, dir)
+			}
+			k.Projects[j] = filepath.Clean(dir)
+		}
+		set.byKey[k.Key] = k
+	}
+	return set, nil
+}
+
+// Lookup returns the API key matching a client's credential
+func (k *KeySet) Lookup(key string) (*APIKey, bool) {
+	apiKey, ok := k.byKey[key]
+	return apiKey, ok
+}
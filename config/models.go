@@ -0,0 +1,144 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Model describes a model alias exposed by the API and how it maps to the
+// Claude CLI
+type Model struct {
+	// ID is the name clients send in the request's model field
+	ID string `json:"id"`
+
+	// CLIModel is passed to the CLI's --model flag. Empty uses the CLI's
+	// default model.
+	CLIModel string `json:"cli_model,omitempty"`
+
+	// FallbackModel is passed to --fallback-model, which switches to it
+	// when the primary model is overloaded
+	FallbackModel string `json:"fallback_model,omitempty"`
+
+	// Tools is the tool policy of requests for the model. Without an
+	// allowed list, DefaultAllowedTools are allowed.
+	Tools *ToolPolicy `json:"tools,omitempty"`
+
+	// InputFormat selects how the conversation is sent to the CLI:
+	// InputText flattens it to a prompt, InputStreamJSON sends it as a
+	// structured user turn
+	InputFormat string `json:"input_format,omitempty"`
+
+	// Workdir runs the model's requests in a project directory instead of
+	// an isolated workspace
+	Workdir string `json:"workdir,omitempty"`
+
+	OwnedBy string `json:"owned_by,omitempty"`
+}
+
+// Input formats of a model
+const (
+	InputText       = "text"
+	InputStreamJSON = "stream-json"
+)
+
+// Catalog is the set of models the API serves
+type Catalog struct {
+	// Default is used when a request omits the model field
+	Default string  `json:"default"`
+	Models  []Model `json:"models"`
+
+	byID map[string]*Model
+}
+
+// DefaultCatalog is served when no MODELS_FILE is configured
+func DefaultCatalog() *Catalog {
+	c, _ := NewCatalog("claude-cli",
+		Model{ID: "claude-cli"},
+		Model{ID: "opus", CLIModel: "opus", FallbackModel: "sonnet"},
+		Model{ID: "sonnet", CLIModel: "sonnet"},
+		Model{ID: "haiku", CLIModel: "haiku"},
+	)
+	return c
+}
+
+// NewCatalog validates a catalog of models. An empty def makes the first
+// model the default.
+func NewCatalog(def string, models ...Model) (*Catalog, error) {
+	if len(models) == 0 {
+		return nil, fmt.Errorf("the catalog defines no models")
+	}
+	c := &Catalog{Default: def, Models: models}
+	if c.Default == "" {
+		c.Default = models[0].ID
+	}
+	if err := c.index(); err != nil {
+		return nil, fmt.Errorf("invalid model catalog: %w", err)
+	}
+	return c, nil
+}
+
+// LoadCatalog reads a model catalog from a JSON file
+func LoadCatalog(path string) (*Catalog, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read models file: %w", err)
+	}
+
+	var catalog Catalog
+	if err := json.Unmarshal(data, &catalog); err != nil {
+		return nil, fmt.Errorf("failed to parse models file: %w", err)
+	}
+
+	if len(catalog.Models) == 0 {
+		return nil, fmt.Errorf("models file defines no models")
+	}
+	return NewCatalog(catalog.Default, catalog.Models...)
+}
+
+// index builds the lookup table and validates the catalog
+func (c *Catalog) index() error {
+	c.byID = make(map[string]*Model, len(c.Models))
+	for i := range c.Models {
+		m := &c.Models[i]
+		if m.ID == "" {
+			return fmt.Errorf("model %d has no id", i)
+		}
+		if _, dup := c.byID[m.ID]; dup {
+			return fmt.Errorf("duplicate model id: %s", m.ID)
+		}
+		if m.OwnedBy == "" {
+			m.OwnedBy = "anthropic"
+		}
+		switch m.InputFormat {
+		case "", InputText, InputStreamJSON:
+		default:
+			return fmt.Errorf("model %s: invalid input_format %q", m.ID, m.InputFormat)
+		}
+		if m.Tools == nil {
+			m.Tools = &ToolPolicy{}
+		}
+		if m.Tools.Allowed == nil {
+			m.Tools.Allowed = DefaultAllowedTools
+		}
+		if err := m.Tools.validate(); err != nil {
+			return fmt.Errorf("model %s: %w", m.ID, err)
+		}
+		c.byID[m.ID] = m
+	}
+
+	if _, ok := c.byID[c.Default]; !ok {
+		return fmt.Errorf("default model %q is not in the catalog", c.Default)
+	}
+	return nil
+}
+
+// Lookup returns the model for a request's model field. An empty name
+// selects the default model.
+func (c *Catalog) Lookup(id string) (*Model, bool) {
+	if id == "" {
+		id = c.Default
+	}
+	m, ok := c.byID[id]
+	return m, ok
+}
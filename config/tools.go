@@ -0,0 +1,152 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// DefaultAllowedTools are allowed for models that don't configure tools
+var DefaultAllowedTools = []string{"WebFetch", "WebSearch"}
+
+// permissionModes lists the CLI's permission modes from most to least
+// restrictive
+var permissionModes = []string{"plan", "default", "acceptEdits", "bypassPermissions"}
+
+// ToolPolicy controls which CLI tools a request may use
+type ToolPolicy struct {
+	// Allowed tools run without asking for permission, and are the only
+	// tools the CLI can use that need it. Entries may be scoped, as in
+	// "Read(./docs/**)". Nil leaves the list unset; an empty list turns
+	// every tool off.
+	Allowed []string `json:"allowed"`
+
+	// Disallowed tools are removed from the model's context
+	Disallowed []string `json:"disallowed,omitempty"`
+
+	// PermissionMode is passed to the CLI's --permission-mode flag
+	PermissionMode string `json:"permission_mode,omitempty"`
+
+	// Dirs are directories the tools may access besides the working
+	// directory. Only models set them.
+	Dirs []string `json:"dirs,omitempty"`
+
+	// Available limits the CLI's built-in tools to those named, so that
+	// tools needing no permission are limited too. Nil leaves them all.
+	// It is set by an API key's allowed list.
+	Available []string `json:"-"`
+}
+
+// validate checks the permission mode
+func (p *ToolPolicy) validate() error {
+	if p.PermissionMode != "" && !slices.Contains(permissionModes, p.PermissionMode) {
+		return fmt.Errorf("invalid permission mode %q", p.PermissionMode)
+	}
+	return nil
+}
+
+// Limit caps the policy at an API key's policy. Allowed tools the key
+// doesn't permit are dropped, only the tools the key allows stay
+// available, its disallowed tools are added, and the more restrictive
+// permission mode wins.
+func (p ToolPolicy) Limit(limit *ToolPolicy) ToolPolicy {
+	if limit == nil {
+		return p
+	}
+	if limit.Allowed != nil {
+		allowed := []string{}
+		for _, tool := range p.Allowed {
+			if permits(limit.Allowed, tool) {
+				allowed = append(allowed, tool)
+			}
+		}
+		p.Allowed = allowed
+		p.Available = available(p.Available, limit.Allowed)
+	}
+	p.Disallowed = union(p.Disallowed, limit.Disallowed)
+	if modeRank(limit.PermissionMode) < modeRank(p.PermissionMode) {
+		p.PermissionMode = limit.PermissionMode
+	}
+	return p
+}
+
+// Narrow applies a request's own tool settings, which may only remove
+// tools and lower the permission mode. Asking for anything the policy
+// doesn't permit is an error.
+func (p ToolPolicy) Narrow(req ToolPolicy) (ToolPolicy, error) {
+	if err := req.validate(); err != nil {
+		return p, err
+	}
+	if req.Allowed != nil {
+		for _, tool := range req.Allowed {
+			if !permits(p.Allowed, tool) {
+				return p, fmt.Errorf("tool %q is not permitted", tool)
+			}
+		}
+		p.Allowed = req.Allowed
+	}
+	p.Disallowed = union(p.Disallowed, req.Disallowed)
+	if req.PermissionMode != "" {
+		if modeRank(req.PermissionMode) > modeRank(p.PermissionMode) {
+			return p, fmt.Errorf("permission mode %q is not permitted", req.PermissionMode)
+		}
+		p.PermissionMode = req.PermissionMode
+	}
+	return p, nil
+}
+
+// Permits reports whether the CLI may use tool at all under the policy.
+// Tools that need no permission, such as Read, can be used unless they are
+// disallowed, not available or tools are off.
+func (p ToolPolicy) Permits(tool string) bool {
+	if p.Allowed != nil && len(p.Allowed) == 0 {
+		return false
+	}
+	name, _, _ := strings.Cut(tool, "(")
+	if p.Available != nil && !slices.Contains(p.Available, name) {
+		return false
+	}
+	return !slices.Contains(p.Disallowed, tool) && !slices.Contains(p.Disallowed, name)
+}
+
+// available narrows the available tools to those allowed names, scoped or
+// not. Nil tools stands for all of them.
+func available(tools, allowed []string) []string {
+	names := []string{}
+	for _, tool := range allowed {
+		name, _, _ := strings.Cut(tool, "(")
+		if (tools == nil || slices.Contains(tools, name)) && !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// permits reports whether allowed covers tool, either exactly or because
+// allowed holds the unscoped tool, which covers every scope of it
+func permits(allowed []string, tool string) bool {
+	if slices.Contains(allowed, tool) {
+		return true
+	}
+	name, _, scoped := strings.Cut(tool, "(")
+	return scoped && slices.Contains(allowed, name)
+}
+
+func union(a, b []string) []string {
+	out := slices.Clip(a)
+	for _, s := range b {
+		if !slices.Contains(out, s) {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// modeRank orders permission modes by how much they permit. The empty mode
+// is the CLI's default.
+func modeRank(mode string) int {
+	if mode == "" {
+		mode = "default"
+	}
+	return slices.Index(permissionModes, mode)
+}
@@ -0,0 +1,34 @@
+package config
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestToolPolicyLimit(t *testing.T) {
+	model := ToolPolicy{Allowed: []string{"Read", "Grep", "WebFetch", "WebSearch"}}
+
+	// A key's allowed list limits the available tools too, including
+	// those that need no permission
+	limited := model.Limit(&ToolPolicy{Allowed: []string{"WebSearch", "Read(./docs/**)"}})
+	if !slices.Equal(limited.Allowed, []string{"WebSearch"}) {
+		t.Errorf("allowed = %q, want WebSearch", limited.Allowed)
+	}
+	if !slices.Equal(limited.Available, []string{"WebSearch", "Read"}) {
+		t.Errorf("available = %q, want WebSearch and Read", limited.Available)
+	}
+	if limited.Permits("Grep") || !limited.Permits("Read") {
+		t.Errorf("Grep permitted %v and Read %v, want only Read", limited.Permits("Grep"), limited.Permits("Read"))
+	}
+
+	// A second limit can only narrow what is available
+	again := limited.Limit(&ToolPolicy{Allowed: []string{"Read", "Grep"}})
+	if !slices.Equal(again.Available, []string{"Read"}) {
+		t.Errorf("available = %q, want Read", again.Available)
+	}
+
+	// Without an allowed list the key leaves the tools as they are
+	if open := model.Limit(&ToolPolicy{Disallowed: []string{"Bash"}}); open.Available != nil || !open.Permits("Grep") {
+		t.Errorf("available = %q, want every tool", open.Available)
+	}
+}
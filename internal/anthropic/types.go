@@ -0,0 +1,120 @@
+package anthropic
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// MessagesRequest represents an Anthropic Messages API request
+type MessagesRequest struct {
+	Model         string         `json:"model"`
+	Messages      []Message      `json:"messages"`
+	System        Content        `json:"system,omitempty"`
+	MaxTokens     int            `json:"max_tokens,omitempty"`
+	StopSequences []string       `json:"stop_sequences,omitempty"`
+	Stream        bool           `json:"stream,omitempty"`
+	Temperature   float64        `json:"temperature,omitempty"`
+	TopP          float64        `json:"top_p,omitempty"`
+	TopK          int            `json:"top_k,omitempty"`
+	Tools         []any          `json:"tools,omitempty"`
+	Metadata      map[string]any `json:"metadata,omitempty"`
+}
+
+// Message represents a conversation turn
+type Message struct {
+	Role    string  `json:"role"`
+	Content Content `json:"content"`
+}
+
+// Content is a list of content blocks. It also accepts a plain string,
+// which is treated as a single text block.
+type Content []ContentBlock
+
+// UnmarshalJSON accepts a string or an array of content blocks
+func (c *Content) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	switch {
+	case bytes.Equal(data, []byte("null")):
+		*c = nil
+		return nil
+	case len(data) > 0 && data[0] == '"':
+		var text string
+		if err := json.Unmarshal(data, &text); err != nil {
+			return err
+		}
+		*c = Content{{Type: "text", Text: text}}
+		return nil
+	case len(data) > 0 && data[0] == '[':
+		var blocks []ContentBlock
+		if err := json.Unmarshal(data, &blocks); err != nil {
+			return err
+		}
+		*c = blocks
+		return nil
+	default:
+		return fmt.Errorf("content must be a string or an array of content blocks")
+	}
+}
+
+// ContentBlock represents a single content block
+type ContentBlock struct {
+	Type string `json:"type"`
+
+	// For text blocks
+	Text string `json:"text,omitempty"`
+
+	// For image and document blocks
+	Source *Source `json:"source,omitempty"`
+
+	// For tool_use blocks
+	ID    string `json:"id,omitempty"`
+	Name  string `json:"name,omitempty"`
+	Input any    `json:"input,omitempty"`
+
+	// For tool_result blocks
+	ToolUseID string  `json:"tool_use_id,omitempty"`
+	Content   Content `json:"content,omitempty"`
+	IsError   bool    `json:"is_error,omitempty"`
+}
+
+// Source holds the data of an image or document block
+type Source struct {
+	Type      string `json:"type"`
+	MediaType string `json:"media_type,omitempty"`
+	Data      string `json:"data,omitempty"`
+	URL       string `json:"url,omitempty"`
+}
+
+// MessagesResponse represents a non-streaming Messages API response
+type MessagesResponse struct {
+	ID           string         `json:"id"`
+	Type         string         `json:"type"`
+	Role         string         `json:"role"`
+	Model        string         `json:"model"`
+	Content      []ContentBlock `json:"content"`
+	StopReason   *string        `json:"stop_reason"`
+	StopSequence *string        `json:"stop_sequence"`
+	Usage        Usage          `json:"usage"`
+}
+
+// Usage represents token usage
+type Usage struct {
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens,omitempty"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens,omitempty"`
+}
+
+// ErrorResponse represents an error response
+type ErrorResponse struct {
+	Type      string      `json:"type"`
+	Error     ErrorDetail `json:"error"`
+	RequestID string      `json:"request_id,omitempty"`
+}
+
+// ErrorDetail contains error details
+type ErrorDetail struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
@@ -0,0 +1,247 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+
+	"claude-cli-as-openai-api/internal/claude"
+	"claude-cli-as-openai-api/internal/converter"
+	"claude-cli-as-openai-api/internal/openai"
+	"claude-cli-as-openai-api/internal/workspace"
+)
+
+// chatCall holds the state of one chat completion request
+type chatCall struct {
+	requestID string
+	model     string
+	messages  []openai.Message
+	opts      []converter.Option
+	cliReq    *claude.Request
+
+	// client is the key of the client, which only resumes its own
+	// sessions
+	client string
+
+	// n is the number of choices, each produced by its own CLI run
+	n            int
+	includeUsage bool
+
+	// limited is set when stop sequences or max_tokens may cut the output
+	limited bool
+
+	// format is the JSON response format the reply is validated against
+	format *openai.ResponseFormat
+
+	// workspace is where the CLI runs; sessions it creates are bound to it
+	workspace *workspace.Workspace
+
+	// replay builds the full-history request. It is set when cliReq
+	// resumes a cached session, in case the CLI can't resume it.
+	replay func() (*claude.Request, error)
+}
+
+// progressEvent reports the web tool activity of one choice
+type progressEvent struct {
+	Index int `json:"index"`
+	claude.ToolActivity
+}
+
+// choiceOpts returns the converter options for one choice
+func (c *chatCall) choiceOpts(index int) []converter.Option {
+	return append(slices.Clip(c.opts), converter.WithChoiceIndex(index))
+}
+
+// execute runs a non-streaming chat call, replaying the whole conversation
+// if its cached session can't be resumed
+func (h *Handlers) execute(ctx context.Context, call *chatCall) (*claude.JSONResponse, error) {
+	resp, err := h.backend.ExecuteRequest(ctx, call.cliReq)
+	if err == nil || call.replay == nil || ctx.Err() != nil {
+		return resp, err
+	}
+
+	replay, replayErr := h.replayRequest(call, err)
+	if replayErr != nil {
+		return nil, err
+	}
+	return h.backend.ExecuteRequest(ctx, replay)
+}
+
+// correction continues the session of a rejected reply with a corrective
+// prompt
+func (c *chatCall) correction(sessionID, prompt string) *chatCall {
+	req := *c.cliReq
+	req.Prompt = prompt
+	req.Input = nil
+	req.ResumeSessionID = sessionID
+	req.ForkSession = false
+
+	next := *c
+	next.cliReq = &req
+	next.replay = nil
+	return &next
+}
+
+// completeChat runs every choice of a non-streaming call and combines them
+// into one response
+func (h *Handlers) completeChat(ctx context.Context, call *chatCall) (*openai.ChatCompletionResponse, error) {
+	responses := make([]*openai.ChatCompletionResponse, call.n)
+	err := fanOut(ctx, call.n, func(ctx context.Context, i int) error {
+		var err error
+		responses[i], err = h.completeChoice(ctx, call, i)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return converter.CombineResponses(responses), nil
+}
+
+// completeChoice runs one choice of a non-streaming call. With a response
+// format the reply is validated, and the model is asked to correct it up
+// to ResponseFormatRetries times within the same session.
+func (h *Handlers) completeChoice(ctx context.Context, call *chatCall, index int) (*openai.ChatCompletionResponse, error) {
+	attempt := call
+	var usage []*openai.Usage
+	for tries := 0; ; tries++ {
+		resp, stopped, err := h.run(ctx, attempt, index)
+		if err != nil {
+			return nil, err
+		}
+
+		response := converter.ConvertFinalResponse(resp, call.requestID, call.model, call.choiceOpts(index)...)
+		usage = append(usage, response.Usage)
+		response.Usage = converter.SumUsage(usage...)
+		choice := &response.Choices[0]
+
+		if call.format != nil && len(choice.Message.ToolCalls) == 0 {
+			if *choice.FinishReason == "length" {
+				return nil, &converter.FormatError{Reason: "the reply reached max_tokens before the JSON was complete"}
+			}
+			content, err := converter.CheckResponseFormat(call.format, choice.Message.Content.String())
+			if err != nil {
+				if tries >= h.cfg.ResponseFormatRetries || resp.SessionID == "" {
+					return nil, fmt.Errorf("no valid reply after %d attempts: %w", tries+1, err)
+				}
+				log.Printf("Reply for %s rejected, asking for a correction: %v", call.requestID, err)
+				attempt = call.correction(resp.SessionID, converter.ResponseFormatCorrection(err))
+				continue
+			}
+			choice.Message.Content = openai.TextContent(content)
+			choice.Message.Annotations = converter.Citations(content, resp.Sources)
+		}
+
+		// A corrected session holds the rejected replies as well
+		h.remember(call, *choice.Message, resp.SessionID, stopped || tries > 0)
+		return response, nil
+	}
+}
+
+// run executes one choice of a non-streaming call. A limited call is
+// converted as it streams, so the CLI can be killed as soon as it is cut.
+// It reports whether the output was cut.
+func (h *Handlers) run(ctx context.Context, call *chatCall, index int) (*claude.JSONResponse, bool, error) {
+	if !call.limited {
+		resp, err := h.execute(ctx, call)
+		return resp, false, err
+	}
+
+	c := converter.NewStreamConverter(call.requestID, call.model, call.choiceOpts(index)...)
+	if err := h.executeStreaming(ctx, call, collect(c)); err != nil {
+		return nil, false, err
+	}
+	return c.Result(), c.Stopped(), nil
+}
+
+// collect returns a stream callback that feeds events to c and ends the run
+// once its output is cut
+func collect(c *converter.StreamConverter) claude.StreamCallback {
+	return func(event *claude.Event) error {
+		c.ConvertEvent(event)
+		if c.Stopped() {
+			return claude.ErrStopStream
+		}
+		return nil
+	}
+}
+
+// executeStreaming runs a streaming chat call. A failed resume is only
+// replayed if nothing has been streamed yet.
+func (h *Handlers) executeStreaming(ctx context.Context, call *chatCall, callback claude.StreamCallback) error {
+	streamed := false
+	err := h.backend.ExecuteStreamingRequest(ctx, call.cliReq, func(event *claude.Event) error {
+		if event.Type != claude.EventInit {
+			streamed = true
+		}
+		return callback(event)
+	})
+	if err == nil || streamed || call.replay == nil || ctx.Err() != nil {
+		return err
+	}
+
+	replay, replayErr := h.replayRequest(call, err)
+	if replayErr != nil {
+		return err
+	}
+	return h.backend.ExecuteStreamingRequest(ctx, replay, callback)
+}
+
+func (h *Handlers) replayRequest(call *chatCall, cause error) (*claude.Request, error) {
+	log.Printf("Resuming session %s failed, replaying conversation: %v", call.cliReq.ResumeSessionID, cause)
+	h.sessions.Forget(call.cliReq.ResumeSessionID)
+	return call.replay()
+}
+
+// remember records the reply so the next turn can resume its session. A
+// run cut at a stop sequence or max_tokens is not recorded, as its session holds more
+// than the reply the client saw.
+func (h *Handlers) remember(call *chatCall, reply openai.Message, sessionID string, stopped bool) {
+	call.workspace.Bind(sessionID)
+	if h.sessions == nil {
+		return
+	}
+	if sessionID == call.cliReq.ResumeSessionID {
+		// The session was continued in place rather than forked, so it
+		// no longer holds the shorter conversations it was stored for
+		h.sessions.Forget(sessionID)
+	}
+	if !stopped {
+		h.sessions.Store(call.client, call.model, call.messages, reply, sessionID)
+	}
+}
+
+// choices validates the n of a request, which defaults to one
+func (h *Handlers) choices(n int) (int, error) {
+	if n == 0 {
+		return 1, nil
+	}
+	if n < 0 || n > h.cfg.MaxChoices {
+		return 0, fmt.Errorf("n must be between 1 and %d", h.cfg.MaxChoices)
+	}
+	return n, nil
+}
+
+// fanOut runs fn concurrently once per choice. The first error cancels the
+// remaining runs and is returned.
+func fanOut(ctx context.Context, n int, fn func(ctx context.Context, index int) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// The first error is kept rather than the context's cause, which is
+	// the parent's once its deadline has passed
+	var first error
+	var once sync.Once
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Go(func() {
+			if err := fn(ctx, i); err != nil {
+				once.Do(func() { first = err })
+				cancel()
+			}
+		})
+	}
+	wg.Wait()
+	return first
+}
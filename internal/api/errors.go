@@ -0,0 +1,173 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"claude-cli-as-openai-api/internal/anthropic"
+	"claude-cli-as-openai-api/internal/claude"
+	"claude-cli-as-openai-api/internal/converter"
+	"claude-cli-as-openai-api/internal/openai"
+	"claude-cli-as-openai-api/internal/scheduler"
+	"claude-cli-as-openai-api/pkg/sse"
+)
+
+// failureClass describes how a kind of CLI failure is reported to clients
+type failureClass struct {
+	status        int
+	errorType     string
+	anthropicType string
+}
+
+// failureClasses maps CLI failure kinds to HTTP statuses and error types.
+// Kinds not listed are server errors.
+var failureClasses = map[claude.ErrorKind]failureClass{
+	claude.ErrorAuth:          {http.StatusUnauthorized, "authentication_error", "authentication_error"},
+	claude.ErrorRateLimit:     {http.StatusTooManyRequests, "rate_limit_error", "rate_limit_error"},
+	claude.ErrorUsageLimit:    {http.StatusTooManyRequests, "rate_limit_error", "rate_limit_error"},
+	claude.ErrorContextLength: {http.StatusBadRequest, "invalid_request_error", "invalid_request_error"},
+	claude.ErrorOverloaded:    {http.StatusServiceUnavailable, "api_error", "overloaded_error"},
+	claude.ErrorUnavailable:   {http.StatusServiceUnavailable, "api_error", "api_error"},
+	claude.ErrorNetwork:       {http.StatusBadGateway, "api_error", "api_error"},
+	claude.ErrorTimeout:       {http.StatusGatewayTimeout, "api_error", "api_error"},
+}
+
+// classifyFailure determines the HTTP status, OpenAI error type and code of
+// a failed request. CLI failures are coded with their kind, so clients can
+// tell a timeout, an authentication failure and a crash apart.
+func classifyFailure(err error) (int, string, string) {
+	var formatErr *converter.FormatError
+	if errors.As(err, &formatErr) {
+		return http.StatusInternalServerError, "api_error", "invalid_response_format"
+	}
+	var queueErr *scheduler.QueueFullError
+	if errors.As(err, &queueErr) {
+		return http.StatusTooManyRequests, "rate_limit_error", "queue_full"
+	}
+
+	var cliErr *claude.Error
+	if !errors.As(err, &cliErr) {
+		return http.StatusInternalServerError, "api_error", ""
+	}
+	class, ok := failureClasses[cliErr.Kind]
+	if !ok {
+		return http.StatusInternalServerError, "api_error", string(cliErr.Kind)
+	}
+	return class.status, class.errorType, string(cliErr.Kind)
+}
+
+// anthropicErrorType maps a failed request to an HTTP status and Anthropic
+// error type
+func anthropicErrorType(err error) (int, string) {
+	var queueErr *scheduler.QueueFullError
+	if errors.As(err, &queueErr) {
+		return http.StatusTooManyRequests, "rate_limit_error"
+	}
+	var cliErr *claude.Error
+	if errors.As(err, &cliErr) {
+		if class, ok := failureClasses[cliErr.Kind]; ok {
+			return class.status, class.anthropicType
+		}
+	}
+	return http.StatusInternalServerError, "api_error"
+}
+
+// setRetryAfter tells the client when a rate or usage limited request may
+// be retried
+func setRetryAfter(w http.ResponseWriter, err error) {
+	var wait time.Duration
+	var cliErr *claude.Error
+	var queueErr *scheduler.QueueFullError
+	switch {
+	case errors.As(err, &cliErr):
+		wait = cliErr.RetryAfter
+	case errors.As(err, &queueErr):
+		wait = queueErr.RetryAfter
+	}
+	if wait > 0 {
+		seconds := int(math.Ceil(wait.Seconds()))
+		w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	}
+}
+
+// errorDetail describes a failed request in an OpenAI error object
+func errorDetail(r *http.Request, err error) openai.ErrorDetail {
+	_, errType, code := classifyFailure(err)
+	detail := openai.ErrorDetail{
+		Message:   err.Error(),
+		Type:      errType,
+		RequestID: requestID(r),
+	}
+	if code != "" {
+		detail.Code = &code
+	}
+	return detail
+}
+
+// writeFailure reports a request that failed while running the CLI, with the
+// status matching the kind of failure
+func (h *Handlers) writeFailure(w http.ResponseWriter, r *http.Request, err error) {
+	log.Printf("Request %s failed: %v", requestID(r), err)
+	status, _, _ := classifyFailure(err)
+	setRetryAfter(w, err)
+	h.writeJSON(w, status, openai.ErrorResponse{Error: errorDetail(r, err)})
+}
+
+// writeAnthropicFailure is writeFailure for the Anthropic Messages API
+func (h *Handlers) writeAnthropicFailure(w http.ResponseWriter, r *http.Request, err error) {
+	log.Printf("Request %s failed: %v", requestID(r), err)
+	status, errType := anthropicErrorType(err)
+	setRetryAfter(w, err)
+	h.writeAnthropicError(w, status, err.Error(), errType)
+}
+
+// clientGone reports whether the client of a request went away. A request
+// that ran out of time is still connected and is told why it failed.
+func clientGone(r *http.Request) bool {
+	return errors.Is(r.Context().Err(), context.Canceled)
+}
+
+// streamFailure reports a stream cut by the request's deadline as a
+// timeout, whatever error the cut caused
+func streamFailure(r *http.Request, err error) error {
+	var cliErr *claude.Error
+	if !errors.Is(r.Context().Err(), context.DeadlineExceeded) ||
+		(errors.As(err, &cliErr) && cliErr.Kind == claude.ErrorTimeout) {
+		return err
+	}
+	return &claude.Error{Kind: claude.ErrorTimeout, Message: "the request timed out", Err: err}
+}
+
+// writeStreamError ends an SSE stream with an error frame. Nothing is
+// written if the client is gone or the stream already carries an error.
+func (h *Handlers) writeStreamError(sseWriter *sse.Writer, r *http.Request, err error) {
+	if clientGone(r) || errors.Is(err, sse.ErrClosed) {
+		return
+	}
+	err = streamFailure(r, err)
+	log.Printf("Request %s failed: %v", requestID(r), err)
+	sseWriter.WriteError(openai.ErrorResponse{Error: errorDetail(r, err)})
+}
+
+// writeAnthropicStreamError ends an Anthropic SSE stream with an error event
+func (h *Handlers) writeAnthropicStreamError(sseWriter *sse.Writer, r *http.Request, err error) {
+	if clientGone(r) || errors.Is(err, sse.ErrClosed) {
+		return
+	}
+	err = streamFailure(r, err)
+	log.Printf("Request %s failed: %v", requestID(r), err)
+	_, errType := anthropicErrorType(err)
+	sseWriter.WriteNamedError("error", anthropic.ErrorResponse{
+		Type: "error",
+		Error: anthropic.ErrorDetail{
+			Type:    errType,
+			Message: err.Error(),
+		},
+		RequestID: requestID(r),
+	})
+}
@@ -1,25 +1,68 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"claude-cli-as-openai-api/config"
 	"claude-cli-as-openai-api/internal/claude"
 	"claude-cli-as-openai-api/internal/converter"
 	"claude-cli-as-openai-api/internal/openai"
+	"claude-cli-as-openai-api/internal/responses"
+	"claude-cli-as-openai-api/internal/scheduler"
+	"claude-cli-as-openai-api/internal/session"
+	"claude-cli-as-openai-api/internal/workspace"
 	"claude-cli-as-openai-api/pkg/sse"
 )
 
 // Handlers contains HTTP handlers
 type Handlers struct {
-	executor *claude.Executor
+	backend    claude.Backend
+	cfg        *config.Config
+	metrics    *claude.Metrics
+	responses  *responses.Store
+	sessions   *session.Cache
+	workspaces *workspace.Manager
+	scheduler  *scheduler.Scheduler
 }
 
-// NewHandlers creates new handlers
-func NewHandlers(executor *claude.Executor) *Handlers {
-	return &Handlers{executor: executor}
+// NewHandlers creates handlers that run requests on backend
+func NewHandlers(backend claude.Backend, cfg *config.Config) *Handlers {
+	h := &Handlers{
+		backend:   backend,
+		cfg:       cfg,
+		responses: responses.NewStore(cfg.ResponseStoreSize),
+		workspaces: workspace.NewManager(cfg.WorkspaceRoot, cfg.WorkspaceMode,
+			cfg.WorkspaceTTL, cfg.WorkspaceDirs),
+		scheduler: scheduler.New(cfg.MaxConcurrency, cfg.QueueSize, cfg.QueuePerKey),
+	}
+	if cfg.SessionResume {
+		h.sessions = session.NewCache(cfg.SessionCacheSize, cfg.SessionTTL)
+	}
+	return h
+}
+
+// SetPool lets the CLI process pool prepare warm processes in new
+// workspaces
+func (h *Handlers) SetPool(pool workspace.Pool) {
+	if h.cfg.Pool.Size > 0 {
+		// Half the pool prepares new conversations, leaving room for
+		// processes warmed to continue conversations
+		h.workspaces.SetPool(pool, max(1, h.cfg.Pool.Size/2))
+	}
+}
+
+// SetMetrics reports the backend's run counts in health checks
+func (h *Handlers) SetMetrics(metrics *claude.Metrics) {
+	h.metrics = metrics
 }
 
 // HandleChatCompletions handles /v1/chat/completions
@@ -40,54 +83,267 @@ func (h *Handlers) HandleChatCompletions(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	prompt := converter.MessagesToPrompt(req.Messages)
-	requestID := fmt.Sprintf("chatcmpl-%d", time.Now().UnixNano())
-	model := "claude-cli"
+	model, ok := h.cfg.Models.Lookup(req.Model)
+	if !ok {
+		h.writeModelNotFound(w, req.Model)
+		return
+	}
+
+	tools, err := toolPolicy(r, model)
+	if err != nil {
+		h.writeError(w, http.StatusForbidden, err.Error(), "permission_error")
+		return
+	}
+
+	n, err := h.choices(req.N)
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, err.Error(), "invalid_request_error")
+		return
+	}
+
+	stop, err := converter.ParseStop(req.Stop)
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, err.Error(), "invalid_request_error")
+		return
+	}
+
+	maxTokens := req.MaxCompletionTokens
+	if maxTokens == 0 {
+		maxTokens = req.MaxTokens
+	}
+	if maxTokens < 0 {
+		h.writeError(w, http.StatusBadRequest, "max_tokens must be positive", "invalid_request_error")
+		return
+	}
+
+	thinkingTokens, err := converter.ThinkingBudget(req.ReasoningEffort, maxTokens)
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, err.Error(), "invalid_request_error")
+		return
+	}
+
+	toolsEnabled := converter.ToolsEnabled(req.Tools, req.ToolChoice)
+	var toolPrompt string
+	if toolsEnabled {
+		if toolPrompt, err = converter.ToolsToPrompt(req.Tools, req.ToolChoice); err != nil {
+			h.writeError(w, http.StatusBadRequest, err.Error(), "invalid_request_error")
+			return
+		}
+	}
+
+	var format *openai.ResponseFormat
+	var formatPrompt string
+	if converter.ResponseFormatEnabled(req.ResponseFormat) {
+		format = req.ResponseFormat
+		if formatPrompt, err = converter.ResponseFormatToPrompt(format); err != nil {
+			h.writeError(w, http.StatusBadRequest, err.Error(), "invalid_request_error")
+			return
+		}
+	}
+
+	ticket, err := h.admit(w, r, n)
+	if err != nil {
+		h.writeFailure(w, r, err)
+		return
+	}
+	defer ticket.Release()
+
+	attachments := converter.NewAttachments(h.cfg.AllowLocalFiles, h.cfg.AllowRemoteURLs)
+	defer attachments.Cleanup()
+
+	// The system prompt comes from the whole conversation, even when only
+	// the new turns are sent to a resumed session
+	system, err := converter.SystemPrompt(r.Context(), req.Messages, attachments)
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, err.Error(), "invalid_request_error")
+		return
+	}
+
+	var ws *workspace.Workspace
+	buildRequest := func(messages []openai.Message) (*claude.Request, error) {
+		prompt, input, err := conversation(r.Context(), model, messages, attachments, toolPrompt, formatPrompt)
+		if err != nil {
+			return nil, err
+		}
+
+		cliReq := newCLIRequest(prompt, model, tools)
+		cliReq.Input = input
+		cliReq.SystemPrompt = system
+		cliReq.ReplaceSystemPrompt = h.cfg.ReplaceSystemPrompt
+		cliReq.Dir = ws.Dir
+		cliReq.MaxOutputTokens = maxTokens
+		cliReq.ThinkingTokens = thinkingTokens
+		if err := attach(cliReq, tools, attachments); err != nil {
+			return nil, err
+		}
+		return cliReq, nil
+	}
+
+	call := &chatCall{
+		requestID: fmt.Sprintf("chatcmpl-%d", time.Now().UnixNano()),
+		model:     model.ID,
+		messages:  req.Messages,
+		client:    clientKey(r),
+		opts: []converter.Option{
+			converter.WithTools(toolsEnabled),
+			converter.WithStop(stop),
+			converter.WithMaxTokens(maxTokens),
+		},
+		n:            n,
+		includeUsage: req.StreamOptions != nil && req.StreamOptions.IncludeUsage,
+		limited:      len(stop) > 0 || maxTokens > 0,
+		format:       format,
+	}
+
+	// Resume the CLI session that already holds the start of this
+	// conversation and send only the new turns
+	var sessionID string
+	var rest []openai.Message
+	resumed := false
+	if h.sessions != nil {
+		sessionID, rest, resumed = h.sessions.Lookup(call.client, model.ID, req.Messages)
+	}
+
+	ws, err = h.workdir(r, model, sessionID)
+	if err != nil {
+		status, errType := workdirStatus(err)
+		h.writeError(w, status, err.Error(), errType)
+		return
+	}
+	defer ws.Release()
+	call.workspace = ws
+
+	if resumed {
+		call.cliReq, err = buildRequest(rest)
+		if err == nil {
+			// The run continues in a fork, so the cached session still
+			// holds only the prefix when the client regenerates or edits
+			// the next turn, and parallel choices don't share a session
+			call.cliReq.ResumeSessionID = sessionID
+			call.cliReq.ForkSession = true
+			call.replay = sync.OnceValues(func() (*claude.Request, error) {
+				return buildRequest(req.Messages)
+			})
+		}
+	} else {
+		call.cliReq, err = buildRequest(req.Messages)
+	}
+	if errors.Is(err, errReadNotPermitted) {
+		h.writeError(w, http.StatusForbidden, err.Error(), "permission_error")
+		return
+	}
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, err.Error(), "invalid_request_error")
+		return
+	}
 
 	if req.Stream {
-		h.handleStreamingChat(w, r, prompt, requestID, model)
+		h.handleStreamingChat(w, r, call)
 	} else {
-		h.handleNonStreamingChat(w, r, prompt, requestID, model)
+		h.handleNonStreamingChat(w, r, call)
 	}
 }
 
-func (h *Handlers) handleNonStreamingChat(w http.ResponseWriter, r *http.Request, prompt, requestID, model string) {
-	resp, err := h.executor.ExecuteRequest(r.Context(), prompt)
+func (h *Handlers) handleNonStreamingChat(w http.ResponseWriter, r *http.Request, call *chatCall) {
+	response, err := h.completeChat(r.Context(), call)
 	if err != nil {
-		h.writeError(w, http.StatusInternalServerError, err.Error(), "api_error")
+		h.writeFailure(w, r, err)
 		return
 	}
 
-	response := converter.ConvertFinalResponse(resp, requestID, model)
 	h.writeJSON(w, http.StatusOK, response)
 }
 
-func (h *Handlers) handleStreamingChat(w http.ResponseWriter, r *http.Request, prompt, requestID, model string) {
+func (h *Handlers) handleStreamingChat(w http.ResponseWriter, r *http.Request, call *chatCall) {
+	// A reply with a response format is validated before any of it is sent
+	if call.format != nil {
+		h.handleBufferedStreamingChat(w, r, call)
+		return
+	}
+
 	sseWriter, err := sse.NewWriter(w)
 	if err != nil {
 		h.writeError(w, http.StatusInternalServerError, err.Error(), "api_error")
 		return
 	}
 
-	streamConverter := converter.NewStreamConverter(requestID, model)
+	// Each choice streams from its own CLI run; their chunks are
+	// interleaved as they arrive
+	converters := make([]*converter.StreamConverter, call.n)
+	for i := range converters {
+		converters[i] = converter.NewStreamConverter(call.requestID, call.model, call.choiceOpts(i)...)
+	}
 
-	err = h.executor.ExecuteStreamingRequest(r.Context(), prompt, func(event *claude.StreamEvent) error {
-		response := streamConverter.ConvertEvent(event)
-		if response != nil {
-			return sseWriter.WriteEvent(response)
-		}
-		return nil
+	var mu sync.Mutex
+	err = fanOut(r.Context(), call.n, func(ctx context.Context, i int) error {
+		return h.executeStreaming(ctx, call, func(event *claude.Event) error {
+			chunks := converters[i].ConvertEvent(event)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if h.cfg.ToolProgress {
+				for _, activity := range converters[i].Activity() {
+					if err := sseWriter.WriteNamedEvent("progress", progressEvent{Index: i, ToolActivity: activity}); err != nil {
+						return err
+					}
+				}
+			}
+			for _, response := range chunks {
+				if err := sseWriter.WriteEvent(response); err != nil {
+					return err
+				}
+			}
+			if converters[i].Stopped() {
+				return claude.ErrStopStream
+			}
+			return nil
+		})
 	})
 
 	if err != nil {
-		// For streaming, we can't send error as JSON after headers are sent
-		// Just log and close the connection
+		// Headers are already sent, so the error goes into the stream
+		h.writeStreamError(sseWriter, r, err)
 		return
 	}
 
+	if call.includeUsage {
+		sseWriter.WriteEvent(converters[0].UsageChunk(streamUsage(converters)))
+	}
+	for _, c := range converters {
+		h.remember(call, c.Message(), c.SessionID(), c.Stopped())
+	}
 	sseWriter.WriteDone()
 }
 
+func (h *Handlers) handleBufferedStreamingChat(w http.ResponseWriter, r *http.Request, call *chatCall) {
+	response, err := h.completeChat(r.Context(), call)
+	if err != nil {
+		h.writeFailure(w, r, err)
+		return
+	}
+
+	sseWriter, err := sse.NewWriter(w)
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, err.Error(), "api_error")
+		return
+	}
+	for _, chunk := range converter.ResponseToChunks(response, call.includeUsage) {
+		if err := sseWriter.WriteEvent(chunk); err != nil {
+			return
+		}
+	}
+	sseWriter.WriteDone()
+}
+
+// streamUsage sums the usage of every choice's stream
+func streamUsage(converters []*converter.StreamConverter) *openai.Usage {
+	usage := make([]*openai.Usage, len(converters))
+	for i, c := range converters {
+		usage[i] = c.Usage()
+	}
+	return converter.SumUsage(usage...)
+}
+
 // HandleCompletions handles /v1/completions (legacy)
 func (h *Handlers) HandleCompletions(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
@@ -101,65 +357,147 @@ func (h *Handlers) HandleCompletions(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	model, ok := h.cfg.Models.Lookup(req.Model)
+	if !ok {
+		h.writeModelNotFound(w, req.Model)
+		return
+	}
+
+	tools, err := toolPolicy(r, model)
+	if err != nil {
+		h.writeError(w, http.StatusForbidden, err.Error(), "permission_error")
+		return
+	}
+
+	n, err := h.choices(req.N)
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, err.Error(), "invalid_request_error")
+		return
+	}
+
+	stop, err := converter.ParseStop(req.Stop)
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, err.Error(), "invalid_request_error")
+		return
+	}
+
+	if req.MaxTokens < 0 {
+		h.writeError(w, http.StatusBadRequest, "max_tokens must be positive", "invalid_request_error")
+		return
+	}
+
 	prompt := converter.PromptStringToPrompt(req.Prompt)
 	requestID := fmt.Sprintf("cmpl-%d", time.Now().UnixNano())
-	model := "claude-cli"
+
+	ticket, err := h.admit(w, r, n)
+	if err != nil {
+		h.writeFailure(w, r, err)
+		return
+	}
+	defer ticket.Release()
+
+	ws, err := h.workdir(r, model, "")
+	if err != nil {
+		status, errType := workdirStatus(err)
+		h.writeError(w, status, err.Error(), errType)
+		return
+	}
+	defer ws.Release()
+
+	cliReq := newCLIRequest(prompt, model, tools)
+	cliReq.Dir = ws.Dir
+	cliReq.MaxOutputTokens = req.MaxTokens
+	includeUsage := req.StreamOptions != nil && req.StreamOptions.IncludeUsage
+
+	opts := []converter.Option{
+		converter.WithStop(stop),
+		converter.WithMaxTokens(req.MaxTokens),
+	}
+	limited := len(stop) > 0 || req.MaxTokens > 0
 
 	if req.Stream {
-		h.handleStreamingCompletion(w, r, prompt, requestID, model)
+		h.handleStreamingCompletion(w, r, cliReq, requestID, model.ID, n, opts, includeUsage)
 	} else {
-		h.handleNonStreamingCompletion(w, r, prompt, requestID, model)
+		h.handleNonStreamingCompletion(w, r, cliReq, requestID, model.ID, n, opts, limited)
 	}
 }
 
-func (h *Handlers) handleNonStreamingCompletion(w http.ResponseWriter, r *http.Request, prompt, requestID, model string) {
-	resp, err := h.executor.ExecuteRequest(r.Context(), prompt)
+func (h *Handlers) handleNonStreamingCompletion(w http.ResponseWriter, r *http.Request, cliReq *claude.Request, requestID, model string, n int, opts []converter.Option, limited bool) {
+	responses := make([]*openai.CompletionResponse, n)
+	err := fanOut(r.Context(), n, func(ctx context.Context, i int) error {
+		var resp *claude.JSONResponse
+		if !limited {
+			var err error
+			if resp, err = h.backend.ExecuteRequest(ctx, cliReq); err != nil {
+				return err
+			}
+		} else {
+			// Stream the output so the CLI can be killed once it is cut
+			c := converter.NewStreamConverter(requestID, model, opts...)
+			if err := h.backend.ExecuteStreamingRequest(ctx, cliReq, collect(c)); err != nil {
+				return err
+			}
+			resp = c.Result()
+		}
+		responses[i] = converter.ConvertToCompletionResponse(resp, requestID, model)
+		return nil
+	})
 	if err != nil {
-		h.writeError(w, http.StatusInternalServerError, err.Error(), "api_error")
+		h.writeFailure(w, r, err)
 		return
 	}
 
-	response := converter.ConvertToCompletionResponse(resp, requestID, model)
-	h.writeJSON(w, http.StatusOK, response)
+	h.writeJSON(w, http.StatusOK, converter.CombineCompletionResponses(responses))
 }
 
-func (h *Handlers) handleStreamingCompletion(w http.ResponseWriter, r *http.Request, prompt, requestID, model string) {
+func (h *Handlers) handleStreamingCompletion(w http.ResponseWriter, r *http.Request, cliReq *claude.Request, requestID, model string, n int, opts []converter.Option, includeUsage bool) {
 	sseWriter, err := sse.NewWriter(w)
 	if err != nil {
 		h.writeError(w, http.StatusInternalServerError, err.Error(), "api_error")
 		return
 	}
 
-	streamConverter := converter.NewStreamConverter(requestID, model)
-
-	err = h.executor.ExecuteStreamingRequest(r.Context(), prompt, func(event *claude.StreamEvent) error {
-		response := streamConverter.ConvertEvent(event)
-		if response != nil {
-			// Convert chat completion chunk to completion chunk for legacy API
-			if len(response.Choices) > 0 && response.Choices[0].Delta != nil {
-				legacyResp := &openai.CompletionResponse{
-					ID:      response.ID,
-					Object:  "text_completion",
-					Created: response.Created,
-					Model:   response.Model,
-					Choices: []openai.CompletionChoice{
-						{
-							Index:        0,
-							Text:         response.Choices[0].Delta.Content,
-							FinishReason: response.Choices[0].FinishReason,
-						},
-					},
+	converters := make([]*converter.StreamConverter, n)
+	for i := range converters {
+		converters[i] = converter.NewStreamConverter(requestID, model, append(slices.Clip(opts), converter.WithChoiceIndex(i))...)
+	}
+
+	// writeLegacy converts chat completion chunks to completion chunks for
+	// the legacy API
+	var mu sync.Mutex
+	writeLegacy := func(chunks ...*openai.ChatCompletionStreamResponse) error {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, response := range chunks {
+			if legacyResp := converter.ConvertToCompletionChunk(response); legacyResp != nil {
+				if err := sseWriter.WriteEvent(legacyResp); err != nil {
+					return err
 				}
-				return sseWriter.WriteEvent(legacyResp)
 			}
 		}
 		return nil
+	}
+
+	err = fanOut(r.Context(), n, func(ctx context.Context, i int) error {
+		return h.backend.ExecuteStreamingRequest(ctx, cliReq, func(event *claude.Event) error {
+			if err := writeLegacy(converters[i].ConvertEvent(event)...); err != nil {
+				return err
+			}
+			if converters[i].Stopped() {
+				return claude.ErrStopStream
+			}
+			return nil
+		})
 	})
 
 	if err != nil {
+		h.writeStreamError(sseWriter, r, err)
 		return
 	}
 
+	if includeUsage {
+		writeLegacy(converters[0].UsageChunk(streamUsage(converters)))
+	}
 	sseWriter.WriteDone()
 }
 
@@ -170,16 +508,18 @@ func (h *Handlers) HandleModels(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	created := time.Now().Unix()
 	response := openai.ModelList{
 		Object: "list",
-		Data: []openai.Model{
-			{
-				ID:      "claude-cli",
-				Object:  "model",
-				Created: time.Now().Unix(),
-				OwnedBy: "anthropic",
-			},
-		},
+		Data:   make([]openai.Model, 0, len(h.cfg.Models.Models)),
+	}
+	for _, m := range h.cfg.Models.Models {
+		response.Data = append(response.Data, openai.Model{
+			ID:      m.ID,
+			Object:  "model",
+			Created: created,
+			OwnedBy: m.OwnedBy,
+		})
 	}
 
 	h.writeJSON(w, http.StatusOK, response)
@@ -187,9 +527,13 @@ func (h *Handlers) HandleModels(w http.ResponseWriter, r *http.Request) {
 
 // HandleHealth handles /health
 func (h *Handlers) HandleHealth(w http.ResponseWriter, r *http.Request) {
+	health := map[string]any{"status": "ok"}
+	if h.metrics != nil {
+		health["runs"] = h.metrics.Stats()
+	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	json.NewEncoder(w).Encode(health)
 }
 
 func (h *Handlers) writeJSON(w http.ResponseWriter, status int, data any) {
@@ -199,12 +543,163 @@ func (h *Handlers) writeJSON(w http.ResponseWriter, status int, data any) {
 }
 
 func (h *Handlers) writeError(w http.ResponseWriter, status int, message, errType string) {
+	h.writeErrorCode(w, status, message, errType, "")
+}
+
+func (h *Handlers) writeErrorCode(w http.ResponseWriter, status int, message, errType, code string) {
+	detail := openai.ErrorDetail{
+		Message:   message,
+		Type:      errType,
+		RequestID: w.Header().Get("X-Request-Id"),
+	}
+	if code != "" {
+		detail.Code = &code
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
-	json.NewEncoder(w).Encode(openai.ErrorResponse{
-		Error: openai.ErrorDetail{
-			Message: message,
-			Type:    errType,
-		},
-	})
+	json.NewEncoder(w).Encode(openai.ErrorResponse{Error: detail})
+}
+
+func (h *Handlers) writeModelNotFound(w http.ResponseWriter, model string) {
+	h.writeErrorCode(w, http.StatusNotFound,
+		fmt.Sprintf("The model `%s` does not exist or you do not have access to it.", model),
+		"invalid_request_error", "model_not_found")
+}
+
+// toolPolicy works out the tools a request may use: the model's policy,
+// capped by the API key's, then narrowed by the request's X-Claude-*
+// headers. Headers asking for more than that are an error.
+func toolPolicy(r *http.Request, model *config.Model) (config.ToolPolicy, error) {
+	policy := *model.Tools
+	if key := apiKey(r); key != nil {
+		policy = policy.Limit(key.Tools)
+	}
+
+	var req config.ToolPolicy
+	if _, ok := r.Header["X-Claude-Allowed-Tools"]; ok {
+		req.Allowed = splitList(r.Header.Get("X-Claude-Allowed-Tools"))
+	}
+	req.Disallowed = splitList(r.Header.Get("X-Claude-Disallowed-Tools"))
+	req.PermissionMode = r.Header.Get("X-Claude-Permission-Mode")
+	return policy.Narrow(req)
+}
+
+// splitList parses a comma-separated header value. An empty value is an
+// empty list.
+func splitList(value string) []string {
+	list := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// workdir picks the working directory of a request: a project directory
+// named in X-Claude-Workdir, the model's workdir, or an isolated workspace
+// that follows the resumed session. With API keys, a request may only name
+// the projects of its key.
+func (h *Handlers) workdir(r *http.Request, model *config.Model, sessionID string) (*workspace.Workspace, error) {
+	if dir := r.Header.Get("X-Claude-Workdir"); dir != "" {
+		var projects []string
+		if key := apiKey(r); key != nil {
+			// A key without projects may not choose one
+			projects = append([]string{}, key.Projects...)
+		}
+		return h.workspaces.Project(dir, projects)
+	}
+	if model.Workdir != "" {
+		return workspace.Static(model.Workdir), nil
+	}
+	return h.workspaces.Acquire(sessionID)
+}
+
+// workdirStatus maps a failure to set up a workspace to an HTTP status and
+// error type
+func workdirStatus(err error) (int, string) {
+	if errors.Is(err, workspace.ErrNotAllowed) {
+		return http.StatusForbidden, "permission_error"
+	}
+	return http.StatusInternalServerError, "api_error"
+}
+
+// admit waits until the scheduler lets the request start runs CLI
+// processes, and reports the queue depth it found and its wait in the
+// response headers. When the queue is full it fails with a QueueFullError,
+// which is written as a 429 with Retry-After. Requests are admitted before
+// their attachments and workspace are set up, so a rejected request
+// doesn't pay for them.
+func (h *Handlers) admit(w http.ResponseWriter, r *http.Request, runs int) (*scheduler.Ticket, error) {
+	ticket, err := h.scheduler.Acquire(r.Context(), clientKey(r), runs)
+	var queueErr *scheduler.QueueFullError
+	switch {
+	case err == nil:
+		w.Header().Set("X-Queue-Depth", strconv.Itoa(ticket.Depth))
+		w.Header().Set("X-Queue-Wait-Ms", strconv.FormatInt(ticket.Wait.Milliseconds(), 10))
+	case errors.As(err, &queueErr):
+		w.Header().Set("X-Queue-Depth", strconv.Itoa(queueErr.Depth))
+	case errors.Is(err, context.DeadlineExceeded):
+		err = &claude.Error{Kind: claude.ErrorTimeout, Message: "timed out waiting for a CLI process", Err: err}
+	}
+	return ticket, err
+}
+
+// conversation renders messages in the model's input format: a text prompt,
+// or structured turns for models with input_format stream-json. before and
+// after are server instructions placed around the conversation.
+func conversation(ctx context.Context, model *config.Model, messages []openai.Message, attachments *converter.Attachments, before, after string) (string, []claude.InputMessage, error) {
+	if model.InputFormat == config.InputStreamJSON {
+		input, err := converter.MessagesToInput(ctx, messages, attachments)
+		if err != nil {
+			return "", nil, err
+		}
+		return "", converter.WrapInput(input, before, after), nil
+	}
+
+	prompt, err := converter.MessagesToPrompt(ctx, messages, attachments)
+	if err != nil {
+		return "", nil, err
+	}
+	if before != "" {
+		prompt = before + "\n\n" + prompt
+	}
+	if after != "" {
+		prompt += "\n\n" + after
+	}
+	return prompt, nil, nil
+}
+
+// errReadNotPermitted rejects attachments the CLI isn't permitted to read
+var errReadNotPermitted = errors.New("attachments need the Read tool, which the tool policy does not permit")
+
+// attach lets the CLI read the request's staged attachments, with Read
+// scoped to their directory. It fails if the tool policy doesn't permit
+// Read.
+func attach(cliReq *claude.Request, tools config.ToolPolicy, attachments *converter.Attachments) error {
+	dir := attachments.Dir()
+	if dir == "" {
+		return nil
+	}
+	if !tools.Permits("Read") {
+		return errReadNotPermitted
+	}
+	cliReq.AddAttachments(dir)
+	return nil
+}
+
+// newCLIRequest creates a CLI request for a prompt using a catalog model
+// and the request's tool policy
+func newCLIRequest(prompt string, model *config.Model, tools config.ToolPolicy) *claude.Request {
+	return &claude.Request{
+		Prompt:          prompt,
+		Model:           model.CLIModel,
+		FallbackModel:   model.FallbackModel,
+		AddDirs:         slices.Clone(tools.Dirs),
+		Tools:           tools.Available,
+		AllowedTools:    slices.Clone(tools.Allowed),
+		DisallowedTools: tools.Disallowed,
+		PermissionMode:  tools.PermissionMode,
+	}
 }
@@ -0,0 +1,347 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"claude-cli-as-openai-api/config"
+	"claude-cli-as-openai-api/internal/claude"
+	"claude-cli-as-openai-api/internal/openai"
+	"claude-cli-as-openai-api/internal/workspace"
+)
+
+// testConfig returns a configuration for tests, independent of the
+// environment the tests run in
+func testConfig(t *testing.T) *config.Config {
+	t.Helper()
+	return &config.Config{
+		Models:                config.DefaultCatalog(),
+		ResponseStoreSize:     100,
+		SessionResume:         true,
+		SessionCacheSize:      100,
+		SessionTTL:            time.Hour,
+		MaxConcurrency:        8,
+		MaxChoices:            4,
+		QueueSize:             64,
+		QueuePerKey:           16,
+		RequestTimeout:        time.Minute,
+		MaxRequestTimeout:     time.Hour,
+		ResponseFormatRetries: 2,
+		WorkspaceMode:         workspace.ModeSession,
+		WorkspaceRoot:         t.TempDir(),
+		WorkspaceTTL:          time.Hour,
+	}
+}
+
+// newTestServer serves the API on a fake backend
+func newTestServer(t *testing.T, cfg *config.Config, runs ...claude.FakeRun) (*httptest.Server, *claude.Fake) {
+	t.Helper()
+	fake := claude.NewFake(runs...)
+	srv := httptest.NewServer(NewRouter(NewHandlers(fake, cfg)))
+	t.Cleanup(srv.Close)
+	return srv, fake
+}
+
+// post sends a JSON request and returns the response status and body
+func post(t *testing.T, srv *httptest.Server, path, body string, header map[string]string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, srv.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(data)
+}
+
+// postChat sends a chat completion request and decodes its response,
+// failing the test on any other response
+func postChat(t *testing.T, srv *httptest.Server, body string, header map[string]string) *openai.ChatCompletionResponse {
+	t.Helper()
+	status, body := post(t, srv, "/v1/chat/completions", body, header)
+	if status != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", status, body)
+	}
+	var resp openai.ChatCompletionResponse
+	if err := json.Unmarshal([]byte(body), &resp); err != nil {
+		t.Fatalf("invalid response %s: %v", body, err)
+	}
+	return &resp
+}
+
+func TestChatCompletion(t *testing.T) {
+	srv, fake := newTestServer(t, testConfig(t), claude.TextRun("Hello!"))
+
+	resp := postChat(t, srv,
+		`{"model":"sonnet","messages":[{"role":"user","content":"hi"}]}`, nil)
+	if len(resp.Choices) != 1 || resp.Choices[0].Message.Content.String() != "Hello!" {
+		t.Errorf("choices = %+v, want one choice saying Hello!", resp.Choices)
+	}
+	if reqs := fake.Requests(); len(reqs) != 1 || reqs[0].Model != "sonnet" {
+		t.Errorf("requests = %+v, want one for sonnet", reqs)
+	}
+}
+
+func TestChatFailure(t *testing.T) {
+	srv, _ := newTestServer(t, testConfig(t), claude.ErrorRun("API Error: 529 Overloaded"))
+
+	status, body := post(t, srv, "/v1/chat/completions",
+		`{"model":"sonnet","messages":[{"role":"user","content":"hi"}]}`, nil)
+	if status != http.StatusServiceUnavailable || !strings.Contains(body, `"code":"overloaded"`) {
+		t.Errorf("got %d %s, want 503 with code overloaded", status, body)
+	}
+}
+
+func TestChatTimeout(t *testing.T) {
+	slow := claude.TextRun("too late")
+	slow.Delay = time.Second
+	srv, _ := newTestServer(t, testConfig(t), slow, slow)
+	timeout := map[string]string{"X-Claude-Timeout": "50ms"}
+
+	t.Run("non-streaming", func(t *testing.T) {
+		status, body := post(t, srv, "/v1/chat/completions",
+			`{"model":"sonnet","messages":[{"role":"user","content":"hi"}]}`, timeout)
+		if status != http.StatusGatewayTimeout || !strings.Contains(body, `"code":"timeout"`) {
+			t.Errorf("got %d %s, want 504 with code timeout", status, body)
+		}
+	})
+
+	t.Run("streaming", func(t *testing.T) {
+		_, body := post(t, srv, "/v1/chat/completions",
+			`{"model":"sonnet","stream":true,"messages":[{"role":"user","content":"hi"}]}`, timeout)
+		if !strings.Contains(body, `"code":"timeout"`) {
+			t.Errorf("stream = %s, want a timeout error frame", body)
+		}
+		if strings.Contains(body, "[DONE]") {
+			t.Errorf("stream = %s, want it to end without [DONE]", body)
+		}
+	})
+}
+
+func TestChatResumeForks(t *testing.T) {
+	srv, fake := newTestServer(t, testConfig(t), claude.TextRun("Hello!"), claude.TextRun("Bye!"))
+
+	postChat(t, srv,
+		`{"model":"sonnet","messages":[{"role":"user","content":"hi"}]}`, nil)
+	postChat(t, srv, `{"model":"sonnet","messages":[
+		{"role":"user","content":"hi"},
+		{"role":"assistant","content":"Hello!"},
+		{"role":"user","content":"bye"}]}`, nil)
+
+	reqs := fake.Requests()
+	if len(reqs) != 2 {
+		t.Fatalf("ran %d requests, want 2", len(reqs))
+	}
+	if reqs[0].ResumeSessionID != "" {
+		t.Errorf("first request resumed %q", reqs[0].ResumeSessionID)
+	}
+	// The conversation may branch from here again, so the session is
+	// forked rather than continued
+	if reqs[1].ResumeSessionID != "fake-session" || !reqs[1].ForkSession {
+		t.Errorf("follow-up resumed %q with fork %v, want a fork of fake-session",
+			reqs[1].ResumeSessionID, reqs[1].ForkSession)
+	}
+}
+
+func TestChatResumeScopedToClient(t *testing.T) {
+	cfg := testConfig(t)
+	keys, err := config.NewKeySet(config.APIKey{Key: "key-a", Name: "a"}, config.APIKey{Key: "key-b", Name: "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.APIKeys = keys
+	srv, fake := newTestServer(t, cfg, claude.TextRun("Hello!"), claude.TextRun("Bye!"))
+
+	postChat(t, srv,
+		`{"model":"sonnet","messages":[{"role":"user","content":"hi"}]}`,
+		map[string]string{"Authorization": "Bearer key-a"})
+	postChat(t, srv, `{"model":"sonnet","messages":[
+		{"role":"user","content":"hi"},
+		{"role":"assistant","content":"Hello!"},
+		{"role":"user","content":"bye"}]}`,
+		map[string]string{"Authorization": "Bearer key-b"})
+
+	if reqs := fake.Requests(); len(reqs) != 2 || reqs[1].ResumeSessionID != "" {
+		t.Errorf("another client's follow-up resumed %q, want a new session", reqs[1].ResumeSessionID)
+	}
+}
+
+func TestChatBranchAfterSessionContinuedInPlace(t *testing.T) {
+	// The fake backend reports the session it resumed, as a warm process
+	// that continues its session in place does
+	srv, fake := newTestServer(t, testConfig(t),
+		claude.TextRun("Hello!"), claude.TextRun("Bye!"), claude.TextRun("Again!"))
+
+	postChat(t, srv, `{"model":"sonnet","messages":[{"role":"user","content":"hi"}]}`, nil)
+	postChat(t, srv, `{"model":"sonnet","messages":[
+		{"role":"user","content":"hi"},
+		{"role":"assistant","content":"Hello!"},
+		{"role":"user","content":"bye"}]}`, nil)
+	postChat(t, srv, `{"model":"sonnet","messages":[
+		{"role":"user","content":"hi"},
+		{"role":"assistant","content":"Hello!"},
+		{"role":"user","content":"again"}]}`, nil)
+
+	// The session now holds the bye turn too, so the branch can't resume it
+	if reqs := fake.Requests(); len(reqs) != 3 || reqs[2].ResumeSessionID != "" {
+		t.Errorf("branch resumed %q, want the conversation replayed", reqs[2].ResumeSessionID)
+	}
+}
+
+func TestKeyLimitsAvailableTools(t *testing.T) {
+	cfg := testConfig(t)
+	keys, err := config.NewKeySet(config.APIKey{Key: "key-web", Name: "web",
+		Tools: &config.ToolPolicy{Allowed: []string{"WebSearch"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.APIKeys = keys
+	srv, fake := newTestServer(t, cfg, claude.TextRun("Hello!"))
+
+	postChat(t, srv, `{"model":"sonnet","messages":[{"role":"user","content":"hi"}]}`,
+		map[string]string{"Authorization": "Bearer key-web"})
+	// Read and Grep need no permission, so they must not be available
+	if reqs := fake.Requests(); len(reqs) != 1 || !slices.Equal(reqs[0].Tools, []string{"WebSearch"}) {
+		t.Errorf("requests = %+v, want the tools limited to WebSearch", reqs)
+	}
+
+	status, body := post(t, srv, "/v1/chat/completions", `{"model":"sonnet","messages":[{"role":"user","content":[
+		{"type":"file","file":{"filename":"a.txt","file_data":"aGk="}}]}]}`,
+		map[string]string{"Authorization": "Bearer key-web"})
+	if status != http.StatusForbidden {
+		t.Errorf("attachment without Read got %d %s, want 403", status, body)
+	}
+}
+
+func TestWorkdirScopedToKey(t *testing.T) {
+	root := t.TempDir()
+	mine, theirs := filepath.Join(root, "mine"), filepath.Join(root, "theirs")
+	for _, dir := range []string{mine, theirs} {
+		if err := os.Mkdir(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg := testConfig(t)
+	cfg.WorkspaceDirs = []string{root}
+	keys, err := config.NewKeySet(
+		config.APIKey{Key: "key-a", Name: "a", Projects: []string{mine}},
+		config.APIKey{Key: "key-b", Name: "b", Projects: []string{theirs}},
+		config.APIKey{Key: "key-c", Name: "c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.APIKeys = keys
+	srv, fake := newTestServer(t, cfg, claude.TextRun("Hello!"))
+
+	chat := `{"model":"sonnet","messages":[{"role":"user","content":"hi"}]}`
+	postChat(t, srv, chat, map[string]string{"Authorization": "Bearer key-a", "X-Claude-Workdir": mine})
+	if reqs := fake.Requests(); len(reqs) != 1 || reqs[0].Dir != mine {
+		t.Errorf("requests = %+v, want one in %s", reqs, mine)
+	}
+
+	for _, key := range []string{"key-a", "key-c"} {
+		status, body := post(t, srv, "/v1/chat/completions", chat,
+			map[string]string{"Authorization": "Bearer " + key, "X-Claude-Workdir": theirs})
+		if status != http.StatusForbidden || !strings.Contains(body, "permission_error") {
+			t.Errorf("%s in another key's project got %d %s, want 403", key, status, body)
+		}
+	}
+}
+
+func TestMessagesStreamStopSequence(t *testing.T) {
+	srv, _ := newTestServer(t, testConfig(t), claude.TextRun("Hello there, friend."))
+
+	_, body := post(t, srv, "/v1/messages", `{"model":"sonnet","max_tokens":100,"stream":true,
+		"stop_sequences":["friend"],"messages":[{"role":"user","content":"hi"}]}`, nil)
+	if strings.Contains(body, "friend.") || !strings.Contains(body, `"text":"Hello there, "`) {
+		t.Errorf("stream = %s, want the text cut before friend", body)
+	}
+	if !strings.Contains(body, `"stop_reason":"stop_sequence","stop_sequence":"friend"`) {
+		t.Errorf("stream = %s, want it to stop at the sequence", body)
+	}
+	if strings.Contains(body, "msg_fake") || !strings.Contains(body, `"model":"sonnet"`) {
+		t.Errorf("stream = %s, want message_start to carry the message's own id and model", body)
+	}
+}
+
+func TestChatChoices(t *testing.T) {
+	srv, fake := newTestServer(t, testConfig(t), claude.TextRun("one"), claude.TextRun("two"))
+
+	resp := postChat(t, srv,
+		`{"model":"sonnet","n":2,"messages":[{"role":"user","content":"hi"}]}`, nil)
+	if len(resp.Choices) != 2 {
+		t.Fatalf("got %d choices, want 2", len(resp.Choices))
+	}
+	var texts []string
+	for i, choice := range resp.Choices {
+		if choice.Index != i {
+			t.Errorf("choice %d has index %d", i, choice.Index)
+		}
+		texts = append(texts, choice.Message.Content.String())
+	}
+	// The choices run in parallel, so either may take either run
+	slices.Sort(texts)
+	if !slices.Equal(texts, []string{"one", "two"}) {
+		t.Errorf("choices say %q, want one and two", texts)
+	}
+	if n := len(fake.Requests()); n != 2 {
+		t.Errorf("ran %d requests, want 2", n)
+	}
+	if resp.Usage.CompletionTokens != 10 {
+		t.Errorf("completion tokens = %d, want the sum of both runs", resp.Usage.CompletionTokens)
+	}
+
+	status, body := post(t, srv, "/v1/chat/completions",
+		`{"model":"sonnet","n":5,"messages":[{"role":"user","content":"hi"}]}`, nil)
+	if status != http.StatusBadRequest {
+		t.Errorf("n above MAX_CHOICES got %d %s, want 400", status, body)
+	}
+}
+
+func TestQueueFullRejectedBeforeSetup(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.MaxConcurrency = 1
+	cfg.MaxChoices = 1
+	cfg.QueueSize = 0
+	slow := claude.TextRun("slow")
+	slow.Delay = 50 * time.Millisecond
+	srv, fake := newTestServer(t, cfg, slow)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		post(t, srv, "/v1/chat/completions", `{"model":"sonnet","messages":[{"role":"user","content":"hi"}]}`, nil)
+	}()
+	for len(fake.Requests()) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// The image would fail to stage, but the request is turned away
+	// before its attachments are looked at
+	status, body := post(t, srv, "/v1/chat/completions", `{"model":"sonnet","messages":[{"role":"user","content":[
+		{"type":"image_url","image_url":{"url":"https://example.com/cat.png"}}]}]}`, nil)
+	if status != http.StatusTooManyRequests || !strings.Contains(body, `"code":"queue_full"`) {
+		t.Errorf("got %d %s, want 429 queue_full", status, body)
+	}
+	<-done
+}
@@ -0,0 +1,150 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"claude-cli-as-openai-api/internal/anthropic"
+	"claude-cli-as-openai-api/internal/claude"
+	"claude-cli-as-openai-api/internal/converter"
+	"claude-cli-as-openai-api/pkg/sse"
+)
+
+// HandleMessages handles /v1/messages (Anthropic Messages API)
+func (h *Handlers) HandleMessages(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.writeAnthropicError(w, http.StatusMethodNotAllowed, "method not allowed", "invalid_request_error")
+		return
+	}
+
+	var req anthropic.MessagesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeAnthropicError(w, http.StatusBadRequest, "invalid request body: "+err.Error(), "invalid_request_error")
+		return
+	}
+
+	if len(req.Messages) == 0 {
+		h.writeAnthropicError(w, http.StatusBadRequest, "messages: at least one message is required", "invalid_request_error")
+		return
+	}
+
+	model, ok := h.cfg.Models.Lookup(req.Model)
+	if !ok {
+		h.writeAnthropicError(w, http.StatusNotFound, fmt.Sprintf("model: %s", req.Model), "not_found_error")
+		return
+	}
+
+	tools, err := toolPolicy(r, model)
+	if err != nil {
+		h.writeAnthropicError(w, http.StatusForbidden, err.Error(), "permission_error")
+		return
+	}
+
+	if len(req.Tools) > 0 {
+		h.writeAnthropicError(w, http.StatusBadRequest, "tools are not supported on /v1/messages", "invalid_request_error")
+		return
+	}
+
+	messages, err := converter.AnthropicToMessages(&req)
+	if err != nil {
+		h.writeAnthropicError(w, http.StatusBadRequest, err.Error(), "invalid_request_error")
+		return
+	}
+
+	ticket, err := h.admit(w, r, 1)
+	if err != nil {
+		h.writeAnthropicFailure(w, r, err)
+		return
+	}
+	defer ticket.Release()
+
+	attachments := converter.NewAttachments(h.cfg.AllowLocalFiles, h.cfg.AllowRemoteURLs)
+	defer attachments.Cleanup()
+
+	prompt, input, err := conversation(r.Context(), model, messages, attachments, "", "")
+	if err != nil {
+		h.writeAnthropicError(w, http.StatusBadRequest, err.Error(), "invalid_request_error")
+		return
+	}
+	system, err := converter.SystemPrompt(r.Context(), messages, attachments)
+	if err != nil {
+		h.writeAnthropicError(w, http.StatusBadRequest, err.Error(), "invalid_request_error")
+		return
+	}
+
+	messageID := fmt.Sprintf("msg_%d", time.Now().UnixNano())
+
+	ws, err := h.workdir(r, model, "")
+	if err != nil {
+		status, errType := workdirStatus(err)
+		h.writeAnthropicError(w, status, err.Error(), errType)
+		return
+	}
+	defer ws.Release()
+
+	cliReq := newCLIRequest(prompt, model, tools)
+	cliReq.Input = input
+	cliReq.SystemPrompt = system
+	cliReq.ReplaceSystemPrompt = h.cfg.ReplaceSystemPrompt
+	cliReq.Dir = ws.Dir
+	cliReq.MaxOutputTokens = req.MaxTokens
+	if err := attach(cliReq, tools, attachments); err != nil {
+		h.writeAnthropicError(w, http.StatusForbidden, err.Error(), "permission_error")
+		return
+	}
+
+	if req.Stream {
+		h.handleStreamingMessages(w, r, cliReq, messageID, model.ID, req.StopSequences)
+	} else {
+		h.handleNonStreamingMessages(w, r, cliReq, messageID, model.ID, req.StopSequences)
+	}
+}
+
+func (h *Handlers) handleNonStreamingMessages(w http.ResponseWriter, r *http.Request, cliReq *claude.Request, messageID, model string, stopSequences []string) {
+	resp, err := h.backend.ExecuteRequest(r.Context(), cliReq)
+	if err != nil {
+		h.writeAnthropicFailure(w, r, err)
+		return
+	}
+
+	response := converter.ConvertAnthropicResponse(resp, messageID, model, stopSequences)
+	h.writeJSON(w, http.StatusOK, response)
+}
+
+func (h *Handlers) handleStreamingMessages(w http.ResponseWriter, r *http.Request, cliReq *claude.Request, messageID, model string, stopSequences []string) {
+	sseWriter, err := sse.NewWriter(w)
+	if err != nil {
+		h.writeAnthropicError(w, http.StatusInternalServerError, err.Error(), "api_error")
+		return
+	}
+
+	streamConverter := converter.NewAnthropicStreamConverter(messageID, model, stopSequences)
+
+	err = h.backend.ExecuteStreamingRequest(r.Context(), cliReq, func(event *claude.Event) error {
+		for _, e := range streamConverter.ConvertEvent(event) {
+			if err := sseWriter.WriteNamedEvent(e.Name, e.Data); err != nil {
+				return err
+			}
+		}
+		if streamConverter.Stopped() {
+			return claude.ErrStopStream
+		}
+		return nil
+	})
+	if err != nil {
+		h.writeAnthropicStreamError(sseWriter, r, err)
+	}
+}
+
+func (h *Handlers) writeAnthropicError(w http.ResponseWriter, status int, message, errType string) {
+	h.writeJSON(w, status, anthropic.ErrorResponse{
+		Type: "error",
+		Error: anthropic.ErrorDetail{
+			Type:    errType,
+			Message: message,
+		},
+		RequestID: w.Header().Get("X-Request-Id"),
+	})
+}
@@ -1,17 +1,32 @@
 package api
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
+
+	"claude-cli-as-openai-api/config"
+	"claude-cli-as-openai-api/internal/anthropic"
+	"claude-cli-as-openai-api/internal/claude"
+	"claude-cli-as-openai-api/internal/openai"
 )
 
 // CORS adds CORS headers to responses
 func CORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Api-Key, Anthropic-Version, X-Request-Id, X-Claude-Allowed-Tools, X-Claude-Disallowed-Tools, X-Claude-Permission-Mode, X-Claude-Workdir, X-Claude-Timeout")
+		w.Header().Set("Access-Control-Expose-Headers", "X-Request-Id, Retry-After, X-Queue-Depth, X-Queue-Wait-Ms, X-Retry-Count")
 
 		if r.Method == "OPTIONS" {
 			w.WriteHeader(http.StatusOK)
@@ -22,35 +37,207 @@ func CORS(next http.Handler) http.Handler {
 	})
 }
 
+type contextKey int
+
+const (
+	requestIDKey contextKey = iota
+	apiKeyKey
+)
+
+// clientRequestID limits the request IDs accepted from clients
+var clientRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// RequestID assigns every request an ID, reusing the client's X-Request-Id
+// header when it sends one, and returns it in the response headers
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-Id")
+		if !clientRequestID.MatchString(id) {
+			b := make([]byte, 12)
+			rand.Read(b)
+			id = "req_" + hex.EncodeToString(b)
+		}
+
+		w.Header().Set("X-Request-Id", id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey, id)))
+	})
+}
+
+// requestID returns the ID assigned by the RequestID middleware
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey).(string)
+	return id
+}
+
+// Auth requires one of the configured API keys on every request except
+// health checks, sent as "Authorization: Bearer <key>" or in X-Api-Key. A
+// nil key set leaves the API open.
+func Auth(next http.Handler, keys *config.KeySet) http.Handler {
+	if keys == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := r.Header.Get("X-Api-Key")
+		if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			key = strings.TrimSpace(bearer)
+		}
+		apiKey, ok := keys.Lookup(key)
+		if !ok {
+			writeUnauthorized(w, r)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiKeyKey, apiKey)))
+	})
+}
+
+// writeUnauthorized rejects a request without a valid API key, in the
+// error format of the endpoint
+func writeUnauthorized(w http.ResponseWriter, r *http.Request) {
+	writeRejection(w, r, http.StatusUnauthorized, "Invalid or missing API key",
+		"invalid_request_error", "invalid_api_key", "authentication_error")
+}
+
+// writeRejection rejects a request before it reaches its handler, in the
+// error format of the endpoint
+func writeRejection(w http.ResponseWriter, r *http.Request, status int, message, errType, code, anthropicType string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if strings.HasPrefix(r.URL.Path, "/v1/messages") {
+		json.NewEncoder(w).Encode(anthropic.ErrorResponse{
+			Type:      "error",
+			Error:     anthropic.ErrorDetail{Type: anthropicType, Message: message},
+			RequestID: requestID(r),
+		})
+		return
+	}
+	detail := openai.ErrorDetail{
+		Message:   message,
+		Type:      errType,
+		RequestID: requestID(r),
+	}
+	if code != "" {
+		detail.Code = &code
+	}
+	json.NewEncoder(w).Encode(openai.ErrorResponse{Error: detail})
+}
+
+// Timeout gives every request a deadline: def, or the client's
+// X-Claude-Timeout capped at max. The header takes seconds or a duration
+// such as "90s". CLI runs still going at the deadline are stopped and the
+// request fails with a timeout error.
+func Timeout(next http.Handler, def, max time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timeout := def
+		if v := r.Header.Get("X-Claude-Timeout"); v != "" {
+			var err error
+			if timeout, err = parseTimeout(v); err != nil {
+				writeRejection(w, r, http.StatusBadRequest, err.Error(),
+					"invalid_request_error", "", "invalid_request_error")
+				return
+			}
+			timeout = min(timeout, max)
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// parseTimeout reads a timeout given in seconds or as a duration
+func parseTimeout(v string) (time.Duration, error) {
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		var secs float64
+		secs, err = strconv.ParseFloat(v, 64)
+		d = time.Duration(secs * float64(time.Second))
+	}
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid X-Claude-Timeout %q: must be a positive number of seconds or a duration such as 90s", v)
+	}
+	return d, nil
+}
+
+// apiKey returns the API key the request authenticated with, or nil if the
+// API is open
+func apiKey(r *http.Request) *config.APIKey {
+	key, _ := r.Context().Value(apiKeyKey).(*config.APIKey)
+	return key
+}
+
+// clientKey identifies the client of a request, so queued requests of
+// different clients take turns. Clients are told apart by API key, or by
+// address when the API is open.
+func clientKey(r *http.Request) string {
+	if key := apiKey(r); key != nil {
+		return "key:" + key.Key
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "addr:" + host
+}
+
 // Logging logs HTTP requests
 func Logging(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 
-		// Create a response writer wrapper to capture status code
-		wrapped := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		// Create a response writer wrapper to capture status code, and
+		// count the CLI retries made for the request
+		retries := &claude.RetryCounter{}
+		wrapped := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK, retries: retries}
 
-		next.ServeHTTP(wrapped, r)
+		next.ServeHTTP(wrapped, r.WithContext(claude.WithRetryCounter(r.Context(), retries)))
 
-		log.Printf("%s %s %d %v",
+		suffix := ""
+		if n := retries.Count(); n > 0 {
+			suffix = fmt.Sprintf(" retries=%d", n)
+		}
+		log.Printf("%s %s %d %v %s%s",
 			r.Method,
 			r.URL.Path,
 			wrapped.statusCode,
 			time.Since(start),
+			requestID(r),
+			suffix,
 		)
 	})
 }
 
 type responseWriter struct {
 	http.ResponseWriter
-	statusCode int
+	statusCode  int
+	wroteHeader bool
+	retries     *claude.RetryCounter
 }
 
+// WriteHeader reports the retries made before the response started in
+// X-Retry-Count
 func (rw *responseWriter) WriteHeader(code int) {
-	rw.statusCode = code
+	if !rw.wroteHeader {
+		rw.wroteHeader = true
+		rw.statusCode = code
+		if n := rw.retries.Count(); n > 0 {
+			rw.Header().Set("X-Retry-Count", strconv.Itoa(n))
+		}
+	}
 	rw.ResponseWriter.WriteHeader(code)
 }
 
+func (rw *responseWriter) Write(b []byte) (int, error) {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+	return rw.ResponseWriter.Write(b)
+}
+
 // Streaming-compatible Flush
 func (rw *responseWriter) Flush() {
 	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
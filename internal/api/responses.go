@@ -0,0 +1,220 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"claude-cli-as-openai-api/internal/claude"
+	"claude-cli-as-openai-api/internal/converter"
+	"claude-cli-as-openai-api/internal/openai"
+	"claude-cli-as-openai-api/internal/workspace"
+	"claude-cli-as-openai-api/pkg/sse"
+)
+
+// HandleResponses handles /v1/responses
+func (h *Handlers) HandleResponses(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.writeError(w, http.StatusMethodNotAllowed, "method not allowed", "invalid_request_error")
+		return
+	}
+
+	var req openai.ResponseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error(), "invalid_request_error")
+		return
+	}
+
+	if req.Input.Text == "" && len(req.Input.Items) == 0 {
+		h.writeError(w, http.StatusBadRequest, "input is required", "invalid_request_error")
+		return
+	}
+
+	model, ok := h.cfg.Models.Lookup(req.Model)
+	if !ok {
+		h.writeModelNotFound(w, req.Model)
+		return
+	}
+
+	tools, err := toolPolicy(r, model)
+	if err != nil {
+		h.writeError(w, http.StatusForbidden, err.Error(), "permission_error")
+		return
+	}
+
+	if len(req.Tools) > 0 {
+		h.writeError(w, http.StatusBadRequest, "tools are not supported on /v1/responses", "invalid_request_error")
+		return
+	}
+
+	// A follow-up resumes the CLI session of the previous response, so
+	// only the new input needs to be sent
+	var sessionID string
+	if req.PreviousResponseID != "" {
+		_, sessionID, ok = h.responses.Get(responseOwner(r), req.PreviousResponseID)
+		if !ok || sessionID == "" {
+			h.writeError(w, http.StatusNotFound, fmt.Sprintf("previous response with id '%s' not found", req.PreviousResponseID), "invalid_request_error")
+			return
+		}
+	}
+
+	messages, err := converter.ResponseInputToMessages(&req)
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, err.Error(), "invalid_request_error")
+		return
+	}
+
+	ticket, err := h.admit(w, r, 1)
+	if err != nil {
+		h.writeFailure(w, r, err)
+		return
+	}
+	defer ticket.Release()
+
+	attachments := converter.NewAttachments(h.cfg.AllowLocalFiles, h.cfg.AllowRemoteURLs)
+	defer attachments.Cleanup()
+
+	prompt, input, err := conversation(r.Context(), model, messages, attachments, "", "")
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, err.Error(), "invalid_request_error")
+		return
+	}
+	system, err := converter.SystemPrompt(r.Context(), messages, attachments)
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, err.Error(), "invalid_request_error")
+		return
+	}
+
+	responseID := fmt.Sprintf("resp_%d", time.Now().UnixNano())
+	response := converter.NewResponse(responseID, model.ID, req.PreviousResponseID)
+	response.Metadata = req.Metadata
+	store := req.Store == nil || *req.Store
+
+	ws, err := h.workdir(r, model, sessionID)
+	if err != nil {
+		status, errType := workdirStatus(err)
+		h.writeError(w, status, err.Error(), errType)
+		return
+	}
+	defer ws.Release()
+
+	cliReq := newCLIRequest(prompt, model, tools)
+	cliReq.Input = input
+	cliReq.SystemPrompt = system
+	cliReq.ReplaceSystemPrompt = h.cfg.ReplaceSystemPrompt
+	cliReq.Dir = ws.Dir
+	cliReq.ResumeSessionID = sessionID
+	// Follow-ups continue in a fork, so several can branch from the same
+	// response
+	cliReq.ForkSession = sessionID != ""
+	cliReq.MaxOutputTokens = req.MaxOutputTokens
+	if err := attach(cliReq, tools, attachments); err != nil {
+		h.writeError(w, http.StatusForbidden, err.Error(), "permission_error")
+		return
+	}
+
+	if req.Stream {
+		h.handleStreamingResponse(w, r, cliReq, ws, response, store)
+	} else {
+		h.handleNonStreamingResponse(w, r, cliReq, ws, response, store)
+	}
+}
+
+func (h *Handlers) handleNonStreamingResponse(w http.ResponseWriter, r *http.Request, cliReq *claude.Request, ws *workspace.Workspace, response *openai.Response, store bool) {
+	resp, err := h.backend.ExecuteRequest(r.Context(), cliReq)
+	if err != nil {
+		h.writeFailure(w, r, err)
+		return
+	}
+
+	converter.CompleteResponse(response, resp)
+	if store {
+		h.responses.Put(responseOwner(r), response, resp.SessionID)
+		ws.Bind(resp.SessionID)
+	}
+	h.writeJSON(w, http.StatusOK, response)
+}
+
+func (h *Handlers) handleStreamingResponse(w http.ResponseWriter, r *http.Request, cliReq *claude.Request, ws *workspace.Workspace, response *openai.Response, store bool) {
+	sseWriter, err := sse.NewWriter(w)
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, err.Error(), "api_error")
+		return
+	}
+
+	streamConverter := converter.NewResponseStreamConverter(response)
+	writeEvents := func(events []*openai.ResponseStreamEvent) error {
+		for _, e := range events {
+			if err := sseWriter.WriteNamedEvent(e.Type, e); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := writeEvents(streamConverter.Start()); err != nil {
+		return
+	}
+
+	err = h.backend.ExecuteStreamingRequest(r.Context(), cliReq, func(event *claude.Event) error {
+		return writeEvents(streamConverter.ConvertEvent(event))
+	})
+	if err != nil {
+		if !clientGone(r) {
+			err = streamFailure(r, err)
+			log.Printf("Request %s failed: %v", requestID(r), err)
+			code := "server_error"
+			if _, _, kind := classifyFailure(err); kind != "" {
+				code = kind
+			}
+			writeEvents([]*openai.ResponseStreamEvent{streamConverter.Fail(code, err.Error())})
+		}
+		return
+	}
+
+	if store {
+		h.responses.Put(responseOwner(r), response, streamConverter.SessionID())
+		ws.Bind(streamConverter.SessionID())
+	}
+}
+
+// HandleResponse handles /v1/responses/{id}
+func (h *Handlers) HandleResponse(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/v1/responses/")
+	if id == "" || strings.Contains(id, "/") {
+		h.writeError(w, http.StatusNotFound, "not found", "invalid_request_error")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		response, _, ok := h.responses.Get(responseOwner(r), id)
+		if !ok {
+			h.writeError(w, http.StatusNotFound, fmt.Sprintf("response with id '%s' not found", id), "invalid_request_error")
+			return
+		}
+		h.writeJSON(w, http.StatusOK, response)
+
+	case http.MethodDelete:
+		if !h.responses.Delete(responseOwner(r), id) {
+			h.writeError(w, http.StatusNotFound, fmt.Sprintf("response with id '%s' not found", id), "invalid_request_error")
+			return
+		}
+		h.writeJSON(w, http.StatusOK, openai.DeletedResponse{ID: id, Object: "response", Deleted: true})
+
+	default:
+		h.writeError(w, http.StatusMethodNotAllowed, "method not allowed", "invalid_request_error")
+	}
+}
+
+// responseOwner returns the API key that stored responses belong to, which
+// is empty when the API is open
+func responseOwner(r *http.Request) string {
+	if key := apiKey(r); key != nil {
+		return key.Key
+	}
+	return ""
+}
@@ -12,13 +12,21 @@ func NewRouter(handlers *Handlers) http.Handler {
 	mux.HandleFunc("/v1/chat/completions", handlers.HandleChatCompletions)
 	mux.HandleFunc("/v1/completions", handlers.HandleCompletions)
 	mux.HandleFunc("/v1/models", handlers.HandleModels)
+	mux.HandleFunc("/v1/responses", handlers.HandleResponses)
+	mux.HandleFunc("/v1/responses/", handlers.HandleResponse)
+
+	// Anthropic-compatible endpoints
+	mux.HandleFunc("/v1/messages", handlers.HandleMessages)
 
 	// Health check
 	mux.HandleFunc("/health", handlers.HandleHealth)
 
 	// Apply middleware
 	var handler http.Handler = mux
+	handler = Timeout(handler, handlers.cfg.RequestTimeout, handlers.cfg.MaxRequestTimeout)
+	handler = Auth(handler, handlers.cfg.APIKeys)
 	handler = Logging(handler)
+	handler = RequestID(handler)
 	handler = CORS(handler)
 
 	return handler
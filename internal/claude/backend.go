@@ -0,0 +1,98 @@
+package claude
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Backend runs requests and reports their output as Events. The Executor
+// runs them on the Claude CLI and converts its output, Fake plays back scripted runs, and
+// decorators such as Retry wrap a backend to add behavior.
+type Backend interface {
+	// ExecuteRequest runs a request to the end and returns its result
+	ExecuteRequest(ctx context.Context, req *Request) (*JSONResponse, error)
+
+	// ExecuteStreamingRequest runs a request and passes each of its events
+	// to callback. An error from callback ends the run; ErrStopStream ends
+	// it without error.
+	ExecuteStreamingRequest(ctx context.Context, req *Request, callback StreamCallback) error
+}
+
+// StreamCallback is called for each event of a run
+type StreamCallback func(event *Event) error
+
+// ErrStopStream can be returned by a StreamCallback to end a run early,
+// for example at a stop sequence. The run is stopped and the request
+// returns without error.
+var ErrStopStream = errors.New("stream stopped")
+
+// Decorator wraps a backend to add behavior to its runs
+type Decorator func(Backend) Backend
+
+// Wrap applies decorators to b. The first decorator is the outermost, so
+// it sees the runs before the others do.
+func Wrap(b Backend, decorators ...Decorator) Backend {
+	for i := len(decorators) - 1; i >= 0; i-- {
+		b = decorators[i](b)
+	}
+	return b
+}
+
+// Collect runs a request on a backend's stream and gathers the result. The
+// output is read as a stream, since the CLI's JSON result doesn't say why
+// the model stopped or include its thinking and web sources. If the run
+// reports more than one result, the last is kept and their usage added up.
+func Collect(ctx context.Context, b Backend, req *Request) (*JSONResponse, error) {
+	var resp *JSONResponse
+	var thinking strings.Builder
+	var web WebTracker
+	stopReason := ""
+	err := b.ExecuteStreamingRequest(ctx, req, func(event *Event) error {
+		web.Observe(event)
+		switch event.Type {
+		case EventMessageDelta:
+			if event.StopReason != "" {
+				stopReason = event.StopReason
+			}
+		case EventThinking:
+			thinking.WriteString(event.Text)
+		case EventResult:
+			var usage *Usage
+			if resp != nil {
+				usage = resp.Usage
+			}
+			if event.Usage != nil {
+				if usage == nil {
+					usage = &Usage{}
+				}
+				usage.Add(event.Usage)
+			}
+			resp = &JSONResponse{
+				Type:       "result",
+				Subtype:    "success",
+				CostUSD:    event.CostUSD,
+				DurationMS: event.DurationMS,
+				NumTurns:   event.NumTurns,
+				Result:     event.Text,
+				SessionID:  event.SessionID,
+				Usage:      usage,
+				StopReason: event.StopReason,
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if resp == nil {
+		return nil, fmt.Errorf("claude produced no result")
+	}
+	if resp.StopReason == "" {
+		resp.StopReason = stopReason
+	}
+	resp.Thinking = thinking.String()
+	resp.Sources = web.Sources()
+	return resp, nil
+}
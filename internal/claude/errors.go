@@ -0,0 +1,241 @@
+package claude
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os/exec"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrorKind classifies why a CLI run failed
+type ErrorKind string
+
+const (
+	// ErrorTimeout means the run exceeded its deadline
+	ErrorTimeout ErrorKind = "timeout"
+	// ErrorCanceled means the client went away before the run finished
+	ErrorCanceled ErrorKind = "canceled"
+	// ErrorAuth means the CLI is not logged in, or its credentials were
+	// rejected or have expired
+	ErrorAuth ErrorKind = "authentication"
+	// ErrorRateLimit means the API rate limit was hit
+	ErrorRateLimit ErrorKind = "rate_limit"
+	// ErrorUsageLimit means the account's usage limit was reached
+	ErrorUsageLimit ErrorKind = "usage_limit"
+	// ErrorContextLength means the conversation doesn't fit the model's
+	// context window
+	ErrorContextLength ErrorKind = "context_length_exceeded"
+	// ErrorMaxTurns means the CLI gave up after its maximum number of
+	// agent turns
+	ErrorMaxTurns ErrorKind = "max_turns"
+	// ErrorOverloaded means the API was temporarily overloaded
+	ErrorOverloaded ErrorKind = "overloaded"
+	// ErrorNetwork means the CLI could not reach the API
+	ErrorNetwork ErrorKind = "network"
+	// ErrorUnavailable means the CLI binary is missing or could not be
+	// started
+	ErrorUnavailable ErrorKind = "unavailable"
+	// ErrorCrash means the CLI exited abnormally for another reason
+	ErrorCrash ErrorKind = "crash"
+)
+
+// errorKinds lists every kind of failure
+var errorKinds = []ErrorKind{
+	ErrorTimeout, ErrorCanceled, ErrorAuth, ErrorRateLimit, ErrorUsageLimit,
+	ErrorContextLength, ErrorMaxTurns, ErrorOverloaded, ErrorNetwork,
+	ErrorUnavailable, ErrorCrash,
+}
+
+// ParseErrorKind validates the name of a kind of failure
+func ParseErrorKind(s string) (ErrorKind, error) {
+	if kind := ErrorKind(s); slices.Contains(errorKinds, kind) {
+		return kind, nil
+	}
+	return "", fmt.Errorf("unknown error kind %q", s)
+}
+
+// Error describes a failed CLI run
+type Error struct {
+	Kind ErrorKind
+
+	// Message is the CLI's error output
+	Message string
+
+	// ExitCode is the CLI's exit status. It is -1 if the CLI didn't exit
+	// normally, and 0 if it reported an error result.
+	ExitCode int
+
+	// RetryAfter is how long to wait before retrying a rate or usage
+	// limited run, if known
+	RetryAfter time.Duration
+
+	Err error
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("claude command failed (%s)", e.Kind)
+	}
+	return fmt.Sprintf("claude command failed (%s): %s", e.Kind, e.Message)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// apiStatus finds the HTTP status of a failed API call in the CLI's
+// output, as in "API Error: 529 {...}"
+var apiStatus = regexp.MustCompile(`\bAPI Error: (\d{3})\b`)
+
+// statusKinds classifies the API statuses that say why a run failed
+var statusKinds = map[string]ErrorKind{
+	"401": ErrorAuth,
+	"403": ErrorAuth,
+	"413": ErrorContextLength,
+	"429": ErrorRateLimit,
+	"503": ErrorOverloaded,
+	"529": ErrorOverloaded,
+}
+
+// errorPatterns recognizes failures from the CLI's output when it doesn't
+// carry an API status. Phrases only match as whole words, so a word or
+// number that merely contains one isn't mistaken for it. The first match
+// wins, so more specific patterns come first.
+var errorPatterns = []struct {
+	kind    ErrorKind
+	pattern *regexp.Regexp
+}{
+	{ErrorAuth, wordPattern("invalid api key", "authentication_error", "authentication failed", "unauthorized", "not logged in", "run /login", "oauth token has expired", "token has expired")},
+	{ErrorUsageLimit, wordPattern("usage limit", "credit balance is too low", "quota exceeded")},
+	{ErrorRateLimit, wordPattern("rate limit", "rate_limit_error", "rate limited", "too many requests")},
+	{ErrorContextLength, wordPattern("prompt is too long", "context length", "context window", "maximum context", "too many tokens")},
+	{ErrorOverloaded, wordPattern("overloaded", "overloaded_error")},
+	{ErrorNetwork, wordPattern("econnreset", "econnrefused", "enotfound", "eai_again", "socket hang up", "network error", "fetch failed", "connection error")},
+	{ErrorTimeout, wordPattern("timed out", "etimedout", "request timeout")},
+}
+
+// wordPattern matches any of phrases as whole words, ignoring case
+func wordPattern(phrases ...string) *regexp.Regexp {
+	quoted := make([]string, len(phrases))
+	for i, phrase := range phrases {
+		quoted[i] = regexp.QuoteMeta(phrase)
+	}
+	return regexp.MustCompile(`(?i)\b(?:` + strings.Join(quoted, "|") + `)\b`)
+}
+
+var (
+	// The CLI reports the reset time of a usage limit as a Unix timestamp,
+	// as in "Claude AI usage limit reached|1760000000"
+	usageLimitReset = regexp.MustCompile(`(?i)usage limit reached\|(\d+)`)
+	retryAfterHint  = regexp.MustCompile(`(?i)(?:retry[- ]after:?|try again in)\s*(\d+)\s*s`)
+)
+
+// defaultRetryAfter is suggested for limits that don't say when they reset
+const defaultRetryAfter = time.Minute
+
+// isErrorResult reports whether a result event or response describes a
+// failed run. Some failures, such as hitting the turn limit, only show in
+// the subtype.
+func isErrorResult(subtype string, isError bool) bool {
+	return isError || strings.HasPrefix(subtype, "error")
+}
+
+// newError classifies a failed run from its context, process error, result
+// subtype and error output. err is nil for a run that reported an error
+// result.
+func newError(ctx context.Context, err error, subtype, output string) *Error {
+	if strings.TrimSpace(output) == "" {
+		if err != nil {
+			output = err.Error()
+		} else {
+			output = subtype
+		}
+	}
+	e := &Error{
+		Kind:    classify(subtype, output),
+		Message: summarize(output),
+		Err:     err,
+	}
+
+	if err != nil {
+		e.ExitCode = -1
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			e.ExitCode = exitErr.ExitCode()
+		}
+	}
+
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		e.Kind = ErrorTimeout
+		e.Err = ctx.Err()
+	case ctx.Err() != nil:
+		e.Kind = ErrorCanceled
+		e.Err = ctx.Err()
+	case errors.Is(err, exec.ErrNotFound), errors.Is(err, fs.ErrNotExist):
+		e.Kind = ErrorUnavailable
+	case e.ExitCode == 126 || e.ExitCode == 127:
+		// A wrapper script could not run the CLI
+		e.Kind = ErrorUnavailable
+	}
+
+	if e.Kind == ErrorRateLimit || e.Kind == ErrorUsageLimit {
+		e.RetryAfter = retryAfter(output)
+	}
+	return e
+}
+
+// classify determines the kind of failure from the result subtype and the
+// CLI's output
+func classify(subtype, output string) ErrorKind {
+	if subtype == "error_max_turns" {
+		return ErrorMaxTurns
+	}
+
+	if m := apiStatus.FindStringSubmatch(output); m != nil {
+		if kind, ok := statusKinds[m[1]]; ok {
+			return kind
+		}
+	}
+	for _, p := range errorPatterns {
+		if p.pattern.MatchString(output) {
+			return p.kind
+		}
+	}
+	return ErrorCrash
+}
+
+// retryAfter finds when a limit resets in the CLI's output
+func retryAfter(output string) time.Duration {
+	if m := usageLimitReset.FindStringSubmatch(output); m != nil {
+		if ts, err := strconv.ParseInt(m[1], 10, 64); err == nil {
+			if wait := time.Until(time.Unix(ts, 0)); wait > 0 {
+				return wait
+			}
+		}
+	}
+	if m := retryAfterHint.FindStringSubmatch(output); m != nil {
+		if secs, err := strconv.Atoi(m[1]); err == nil && secs > 0 {
+			return time.Duration(secs) * time.Second
+		}
+	}
+	return defaultRetryAfter
+}
+
+// maxErrorMessage caps the CLI output quoted in an error
+const maxErrorMessage = 1000
+
+// summarize trims error output to a length fit for an error message
+func summarize(output string) string {
+	output = strings.TrimSpace(output)
+	if len(output) > maxErrorMessage {
+		output = strings.ToValidUTF8(output[:maxErrorMessage], "") + "..."
+	}
+	return output
+}
@@ -0,0 +1,39 @@
+package claude
+
+import "testing"
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name    string
+		subtype string
+		output  string
+		want    ErrorKind
+	}{
+		{"max turns", "error_max_turns", "", ErrorMaxTurns},
+		{"auth status", "", `API Error: 401 {"type":"error","error":{"type":"authentication_error"}}`, ErrorAuth},
+		{"rate limit status", "", `API Error: 429 {"type":"error","error":{"type":"rate_limit_error"}}`, ErrorRateLimit},
+		{"overloaded status", "", `API Error: 529 {"type":"error","error":{"type":"overloaded_error"}}`, ErrorOverloaded},
+		{"status decides over text", "", `API Error: 529 request timed out`, ErrorOverloaded},
+		{"unknown status falls back to text", "", `API Error: 500 Request timed out.`, ErrorTimeout},
+		{"not logged in", "", "Invalid API key · Please run /login", ErrorAuth},
+		{"usage limit", "", "Claude AI usage limit reached|1760000000", ErrorUsageLimit},
+		{"rate limit", "", "Rate limit exceeded, retry after 30s", ErrorRateLimit},
+		{"prompt too long", "", "Prompt is too long", ErrorContextLength},
+		{"network", "", "Connection error: ECONNRESET", ErrorNetwork},
+		{"timed out", "", "Request timed out", ErrorTimeout},
+		{"unrecognized", "", "something went wrong", ErrorCrash},
+
+		{"number in text", "", "failed after reading 4012 lines of line 401a.txt", ErrorCrash},
+		{"status without API error", "", "the report lists 429 orders", ErrorCrash},
+		{"word containing a phrase", "", "timeouts.go: unexpected EOF", ErrorCrash},
+		{"bare authentication", "", "the authentication module failed to parse", ErrorCrash},
+		{"phrase inside a word", "", "nonoverloaded flag", ErrorCrash},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classify(tt.subtype, tt.output); got != tt.want {
+				t.Errorf("classify(%q, %q) = %s, want %s", tt.subtype, tt.output, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package claude
+
+// EventType says what an Event reports
+type EventType string
+
+const (
+	// EventInit starts a run and names its session
+	EventInit EventType = "init"
+
+	// EventMessageStart starts a model response, with the usage of its
+	// input
+	EventMessageStart EventType = "message_start"
+
+	// EventBlockStart opens a content block of the response
+	EventBlockStart EventType = "block_start"
+
+	// EventText, EventThinking and EventSignature add text, thinking or a
+	// thinking signature to the open block at Index
+	EventText      EventType = "text"
+	EventThinking  EventType = "thinking"
+	EventSignature EventType = "signature"
+
+	// EventBlockStop closes the block at Index
+	EventBlockStop EventType = "block_stop"
+
+	// EventMessageDelta ends a model response with its stop reason and
+	// output usage
+	EventMessageDelta EventType = "message_delta"
+
+	// EventToolUse and EventToolResult report a call of one of the
+	// backend's own tools, such as WebSearch, and its result
+	EventToolUse    EventType = "tool_use"
+	EventToolResult EventType = "tool_result"
+
+	// EventResult ends the run with its reply
+	EventResult EventType = "result"
+)
+
+// Event is a step of a run, reported the same way whatever backend runs
+// it. Which fields are set depends on Type.
+type Event struct {
+	Type EventType
+
+	// SessionID is the session of init and result events
+	SessionID string
+
+	// Index is the content block of block events, counted from zero in
+	// each model response
+	Index int
+
+	// Block is the type of block a block_start event opens: "text",
+	// "thinking", "redacted_thinking", or a tool block clients don't see
+	Block string
+
+	// Text is the text, thinking or signature a delta adds, the data of a
+	// redacted_thinking block, or the reply of a result
+	Text string
+
+	// StopReason is why the model stopped: "end_turn", "max_tokens",
+	// "stop_sequence", "tool_use" or "refusal"
+	StopReason string
+
+	// Usage is the usage of a response's input on message_start, its
+	// output so far on message_delta, and the run's total on result
+	Usage *Usage
+
+	// Tool is the call of tool_use and tool_result events
+	Tool *ToolCall
+
+	// CostUSD, DurationMS and NumTurns describe a finished run
+	CostUSD    float64
+	DurationMS int
+	NumTurns   int
+}
+
+// ToolCall is a call of one of the backend's own tools
+type ToolCall struct {
+	ID    string
+	Name  string
+	Input map[string]any
+
+	// Output and IsError are the result of the call, set on tool_result
+	// events, which leave Name and Input empty
+	Output  string
+	IsError bool
+}
@@ -2,60 +2,254 @@ package claude
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"slices"
 	"strings"
+	"time"
 )
 
 // Executor handles Claude CLI execution
 type Executor struct {
 	claudePath string
+
+	// grace is how long a CLI process that is being stopped may take to
+	// exit before it is killed
+	grace time.Duration
+
+	// pool keeps warm processes; nil starts one per request
+	pool *pool
 }
 
-// NewExecutor creates a new Claude executor
-func NewExecutor(claudePath string) *Executor {
-	return &Executor{claudePath: claudePath}
+// Request describes a run: what to send the model and the tools, files and
+// session it may use. The Executor passes it to the CLI as flags.
+type Request struct {
+	Prompt string
+
+	// Input replaces Prompt with a structured user turn, sent as
+	// stream-json so the content blocks are kept apart
+	Input []InputMessage
+
+	// Model and FallbackModel select the CLI model; empty uses the CLI's
+	// default
+	Model         string
+	FallbackModel string
+
+	// SystemPrompt is passed to --append-system-prompt, or to
+	// --system-prompt to replace the CLI's own when ReplaceSystemPrompt
+	// is set
+	SystemPrompt        string
+	ReplaceSystemPrompt bool
+
+	// Dir is the working directory of the CLI; empty uses the server's
+	Dir string
+
+	// AddDirs lists extra directories the CLI's tools may access
+	AddDirs []string
+
+	// Tools is passed to --tools to limit the built-in tools the CLI has;
+	// nil leaves them all
+	Tools []string
+
+	// AllowedTools and DisallowedTools are passed to --allowedTools and
+	// --disallowedTools, and PermissionMode to --permission-mode. An
+	// empty, non-nil AllowedTools turns the CLI's tools off.
+	AllowedTools    []string
+	DisallowedTools []string
+	PermissionMode  string
+
+	// ResumeSessionID continues an earlier CLI session instead of
+	// starting a new one
+	ResumeSessionID string
+
+	// ForkSession resumes into a new session ID, so parallel runs can
+	// continue the same session independently
+	ForkSession bool
+
+	// MaxOutputTokens caps the tokens of each model response; zero uses
+	// the CLI's default
+	MaxOutputTokens int
+
+	// ThinkingTokens is the extended thinking budget; zero uses the CLI's
+	// default and ThinkingOff turns thinking off
+	ThinkingTokens int
 }
 
-// ExecuteRequest executes a non-streaming request
-func (e *Executor) ExecuteRequest(ctx context.Context, prompt string) (*JSONResponse, error) {
-	cmd := exec.CommandContext(ctx, e.claudePath, "-p",
-		"--output-format", "json",
-		"--allowedTools", "WebFetch,WebSearch")
+// ThinkingOff as a request's ThinkingTokens turns extended thinking off
+const ThinkingOff = -1
 
-	// Pass prompt via stdin to avoid issues with variadic --allowedTools flag
-	cmd.Stdin = strings.NewReader(prompt)
+// NewExecutor creates a new Claude executor. CLI processes that are
+// stopped get grace to exit before they are killed. With a pool size,
+// requests are run on warm processes kept by the pool. Callers bound how
+// many run at once.
+func NewExecutor(claudePath string, grace time.Duration, pool PoolConfig) *Executor {
+	e := &Executor{claudePath: claudePath, grace: grace}
+	if pool.Size > 0 {
+		e.pool = newPool(claudePath, grace, pool)
+	}
+	return e
+}
 
-	output, err := cmd.Output()
-	if err != nil {
-		if exitErr, ok := err.(*exec.ExitError); ok {
-			return nil, fmt.Errorf("claude command failed: %s", string(exitErr.Stderr))
+// args builds the CLI arguments for a request
+func (e *Executor) args(req *Request, outputFormat string) []string {
+	args := []string{"-p", "--output-format", outputFormat}
+	if outputFormat == "stream-json" {
+		args = append(args, "--verbose", "--include-partial-messages")
+	}
+	if len(req.Input) > 0 {
+		args = append(args, "--input-format", "stream-json")
+	}
+
+	if req.Model != "" {
+		args = append(args, "--model", req.Model)
+	}
+	if req.FallbackModel != "" {
+		args = append(args, "--fallback-model", req.FallbackModel)
+	}
+
+	if req.SystemPrompt != "" {
+		flag := "--append-system-prompt"
+		if req.ReplaceSystemPrompt {
+			flag = "--system-prompt"
 		}
-		return nil, fmt.Errorf("failed to execute claude: %w", err)
+		args = append(args, flag, req.SystemPrompt)
 	}
 
-	var resp JSONResponse
-	if err := json.Unmarshal(output, &resp); err != nil {
-		return nil, fmt.Errorf("failed to parse claude response: %w", err)
+	if req.ResumeSessionID != "" {
+		args = append(args, "--resume", req.ResumeSessionID)
+		if req.ForkSession {
+			args = append(args, "--fork-session")
+		}
 	}
 
-	return &resp, nil
+	if len(req.AddDirs) > 0 {
+		args = append(args, "--add-dir")
+		args = append(args, req.AddDirs...)
+	}
+	tools := req.Tools
+	if req.AllowedTools != nil && len(req.AllowedTools) == 0 {
+		// Without an allowed list the CLI would still use the tools
+		// that need no permission
+		tools = []string{}
+	}
+	if tools != nil {
+		args = append(args, "--tools", strings.Join(tools, ","))
+	}
+	if len(req.AllowedTools) > 0 {
+		args = append(args, "--allowedTools", strings.Join(req.AllowedTools, ","))
+	}
+	if len(req.DisallowedTools) > 0 {
+		args = append(args, "--disallowedTools", strings.Join(req.DisallowedTools, ","))
+	}
+	if req.PermissionMode != "" {
+		args = append(args, "--permission-mode", req.PermissionMode)
+	}
+	return args
 }
 
-// StreamCallback is called for each streaming event
-type StreamCallback func(event *StreamEvent) error
+// AddAttachments lets the CLI read the staged attachments in dir, and
+// nothing else it couldn't read before. The caller checks that the
+// request's tool policy permits Read.
+func (r *Request) AddAttachments(dir string) {
+	r.AddDirs = append(r.AddDirs, dir)
+	if !slices.Contains(r.AllowedTools, "Read") {
+		// A leading "//" makes the rule's path absolute
+		r.AllowedTools = append(r.AllowedTools, "Read(/"+filepath.ToSlash(dir)+"/**)")
+	}
+}
 
-// ExecuteStreamingRequest executes a streaming request
-func (e *Executor) ExecuteStreamingRequest(ctx context.Context, prompt string, callback StreamCallback) error {
-	cmd := exec.CommandContext(ctx, e.claudePath, "-p",
-		"--output-format", "stream-json",
-		"--verbose", "--include-partial-messages",
-		"--allowedTools", "WebFetch,WebSearch")
+// command prepares the CLI process for a request
+func (e *Executor) command(ctx context.Context, req *Request, outputFormat string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, e.claudePath, e.args(req, outputFormat)...)
+	cmd.Dir = req.Dir
+
+	// On cancellation or timeout the CLI and the processes it started get
+	// the grace period to exit. If the CLI itself is still running after
+	// twice that, Wait kills it and gives up on its output.
+	setProcessGroup(cmd)
+	cmd.Cancel = func() error {
+		return stopGroup(cmd.Process, e.grace)
+	}
+	cmd.WaitDelay = 2 * e.grace
 
 	// Pass prompt via stdin to avoid issues with variadic --allowedTools flag
-	cmd.Stdin = strings.NewReader(prompt)
+	cmd.Stdin = strings.NewReader(req.Prompt)
+	if len(req.Input) > 0 {
+		var input bytes.Buffer
+		enc := json.NewEncoder(&input)
+		for _, msg := range req.Input {
+			enc.Encode(msg)
+		}
+		cmd.Stdin = &input
+	}
+
+	if env := req.env(); len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	return cmd
+}
+
+// env returns the environment variables the request sets for the CLI
+func (r *Request) env() []string {
+	var env []string
+	if r.MaxOutputTokens > 0 {
+		env = append(env, fmt.Sprintf("CLAUDE_CODE_MAX_OUTPUT_TOKENS=%d", r.MaxOutputTokens))
+	}
+	if r.ThinkingTokens > 0 || r.ThinkingTokens == ThinkingOff {
+		env = append(env, fmt.Sprintf("MAX_THINKING_TOKENS=%d", max(r.ThinkingTokens, 0)))
+	}
+	return env
+}
+
+// input returns the request as structured input, sending a text prompt as
+// a single user turn
+func (r *Request) input() []InputMessage {
+	if len(r.Input) > 0 {
+		return r.Input
+	}
+	return []InputMessage{{
+		Type:    "user",
+		Message: InputTurn{Role: "user", Content: []ContentBlock{{Type: "text", Text: r.Prompt}}},
+	}}
+}
+
+// deliver passes the backend events of a line of output to callback
+func deliver(event *cliEvent, callback StreamCallback) error {
+	for _, e := range event.events() {
+		if err := callback(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stopGroup asks a CLI process and the processes it started to exit, and
+// kills those still running after grace
+func stopGroup(p *os.Process, grace time.Duration) error {
+	err := signalGroup(p, false)
+	time.AfterFunc(grace, func() { signalGroup(p, true) })
+	return err
+}
+
+// ExecuteRequest executes a non-streaming request
+func (e *Executor) ExecuteRequest(ctx context.Context, req *Request) (*JSONResponse, error) {
+	return Collect(ctx, e, req)
+}
+
+// ExecuteStreamingRequest executes a streaming request, on a pooled
+// process or a new one
+func (e *Executor) ExecuteStreamingRequest(ctx context.Context, req *Request, callback StreamCallback) error {
+	if e.pool != nil {
+		return e.runPooled(ctx, req, callback)
+	}
+
+	cmd := e.command(ctx, req, "stream-json")
 
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
@@ -68,12 +262,16 @@ func (e *Executor) ExecuteStreamingRequest(ctx context.Context, prompt string, c
 	}
 
 	if err := cmd.Start(); err != nil {
-		return fmt.Errorf("failed to start claude: %w", err)
+		return newError(ctx, err, "", "")
 	}
+	// Whatever the CLI started and left running is killed once it exits
+	defer signalGroup(cmd.Process, true)
 
 	// Read stderr in background for error reporting
 	var stderrContent strings.Builder
+	stderrDone := make(chan struct{})
 	go func() {
+		defer close(stderrDone)
 		scanner := bufio.NewScanner(stderr)
 		for scanner.Scan() {
 			stderrContent.WriteString(scanner.Text())
@@ -81,42 +279,62 @@ func (e *Executor) ExecuteStreamingRequest(ctx context.Context, prompt string, c
 		}
 	}()
 
+	// An error result ends the run; it is reported once the process has
+	// exited instead of being passed on as a normal result
+	var errorResult *cliEvent
+	var linger *time.Timer
 	scanner := bufio.NewScanner(stdout)
 	// Increase buffer size for large responses
 	buf := make([]byte, 0, 64*1024)
 	scanner.Buffer(buf, 1024*1024)
 
 	for scanner.Scan() {
-		line := scanner.Text()
-		if line == "" {
+		event, ok := parseEvent(scanner.Text())
+		if !ok {
+			// Skip lines that aren't valid JSON
 			continue
 		}
 
-		var event StreamEvent
-		if err := json.Unmarshal([]byte(line), &event); err != nil {
-			// Skip lines that aren't valid JSON
+		if event.Type == "result" && linger == nil {
+			// The CLI exits after its result. If its output stays open,
+			// usually because a tool process outlived it, the group is
+			// killed so the read ends.
+			linger = time.AfterFunc(e.grace, func() { signalGroup(cmd.Process, true) })
+			defer linger.Stop()
+		}
+
+		if event.isError() {
+			errorResult = event
 			continue
 		}
 
-		if err := callback(&event); err != nil {
-			cmd.Process.Kill()
+		if err := deliver(event, callback); err != nil {
+			stopGroup(cmd.Process, e.grace)
+			cmd.Wait()
+			if errors.Is(err, ErrStopStream) {
+				return nil
+			}
 			return err
 		}
 	}
 
 	if err := scanner.Err(); err != nil {
+		stopGroup(cmd.Process, e.grace)
+		cmd.Wait()
 		return fmt.Errorf("error reading stdout: %w", err)
 	}
 
+	<-stderrDone
 	if err := cmd.Wait(); err != nil {
-		if ctx.Err() != nil {
-			return ctx.Err()
+		message, subtype := stderrContent.String(), ""
+		if errorResult != nil {
+			message = strings.TrimSpace(message + "\n" + errorResult.ResultText)
+			subtype = errorResult.Subtype
 		}
-		errMsg := stderrContent.String()
-		if errMsg != "" {
-			return fmt.Errorf("claude command failed: %s", errMsg)
-		}
-		return fmt.Errorf("claude command failed: %w", err)
+		return newError(ctx, err, subtype, message)
+	}
+	if errorResult != nil {
+		return newError(ctx, nil, errorResult.Subtype, errorResult.ResultText)
 	}
 
 	return nil
@@ -0,0 +1,112 @@
+package claude
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrNoFakeRun is returned by a Fake that has no scripted runs left
+var ErrNoFakeRun = errors.New("fake backend has no scripted run left")
+
+// Fake is a Backend that plays back scripted runs instead of running the
+// CLI, so handlers and decorators can be exercised without a claude binary
+type Fake struct {
+	mu       sync.Mutex
+	runs     []FakeRun
+	requests []*Request
+}
+
+// FakeRun is a scripted run: the events it streams, and the error it ends
+// with
+type FakeRun struct {
+	Events []*Event
+
+	// Delay is waited before each event
+	Delay time.Duration
+
+	Err error
+}
+
+// NewFake creates a fake backend that plays runs, one per request, in
+// order
+func NewFake(runs ...FakeRun) *Fake {
+	return &Fake{runs: runs}
+}
+
+// Script queues more runs
+func (f *Fake) Script(runs ...FakeRun) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.runs = append(f.runs, runs...)
+}
+
+// Requests returns the requests run so far
+func (f *Fake) Requests() []*Request {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]*Request(nil), f.requests...)
+}
+
+// ExecuteRequest plays the next run and returns its result
+func (f *Fake) ExecuteRequest(ctx context.Context, req *Request) (*JSONResponse, error) {
+	return Collect(ctx, f, req)
+}
+
+// ExecuteStreamingRequest plays the next run
+func (f *Fake) ExecuteStreamingRequest(ctx context.Context, req *Request, callback StreamCallback) error {
+	f.mu.Lock()
+	f.requests = append(f.requests, req)
+	if len(f.runs) == 0 {
+		f.mu.Unlock()
+		return ErrNoFakeRun
+	}
+	run := f.runs[0]
+	f.runs = f.runs[1:]
+	f.mu.Unlock()
+
+	for _, event := range run.Events {
+		if run.Delay > 0 {
+			select {
+			case <-time.After(run.Delay):
+			case <-ctx.Done():
+			}
+		}
+		if ctx.Err() != nil {
+			return newError(ctx, ctx.Err(), "", "")
+		}
+		if err := callback(event); err != nil {
+			if errors.Is(err, ErrStopStream) {
+				return nil
+			}
+			return err
+		}
+	}
+	return run.Err
+}
+
+// TextRun scripts a run that replies with text, streamed the way the
+// Executor reports a reply of the CLI
+func TextRun(text string) FakeRun {
+	usage := &Usage{InputTokens: 10, OutputTokens: 5}
+	return FakeRun{Events: []*Event{
+		{Type: EventInit, SessionID: "fake-session"},
+		{Type: EventMessageStart, Usage: usage},
+		{Type: EventBlockStart, Block: "text"},
+		{Type: EventText, Text: text},
+		{Type: EventBlockStop},
+		{Type: EventMessageDelta, StopReason: "end_turn", Usage: usage},
+		{Type: EventResult, SessionID: "fake-session", Text: text, StopReason: "end_turn",
+			Usage: usage, NumTurns: 1},
+	}}
+}
+
+// ErrorRun scripts a run that fails with an error result, classified from
+// message as the CLI's would be
+func ErrorRun(message string) FakeRun {
+	return FakeRun{
+		Events: []*Event{{Type: EventInit, SessionID: "fake-session"}},
+		Err:    newError(context.Background(), nil, "error_during_execution", message),
+	}
+}
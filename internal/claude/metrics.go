@@ -0,0 +1,100 @@
+package claude
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Metrics counts the runs of a backend and how they ended
+type Metrics struct {
+	mu       sync.Mutex
+	active   int
+	runs     int
+	failures map[string]int
+	busy     time.Duration
+}
+
+// RunStats is a snapshot of Metrics
+type RunStats struct {
+	// Active is the number of runs going on
+	Active int `json:"active"`
+
+	// Runs is the number of runs that have ended
+	Runs int `json:"runs"`
+
+	// Failures counts the failed runs by error code, with "other" for
+	// failures that aren't CLI errors
+	Failures map[string]int `json:"failures"`
+
+	// AverageMS is the average duration of the ended runs
+	AverageMS int64 `json:"average_ms"`
+}
+
+// Measure records the runs of a backend in m. Wrapped inside Retry, every
+// attempt counts as a run.
+func Measure(m *Metrics) Decorator {
+	return func(next Backend) Backend {
+		return &measured{next: next, m: m}
+	}
+}
+
+// Stats returns the counts so far
+func (m *Metrics) Stats() RunStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats := RunStats{Active: m.active, Runs: m.runs, Failures: make(map[string]int)}
+	for code, n := range m.failures {
+		stats.Failures[code] = n
+	}
+	if m.runs > 0 {
+		stats.AverageMS = (m.busy / time.Duration(m.runs)).Milliseconds()
+	}
+	return stats
+}
+
+func (m *Metrics) start() time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.active++
+	return time.Now()
+}
+
+func (m *Metrics) end(start time.Time, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.active--
+	m.runs++
+	m.busy += time.Since(start)
+	if err == nil {
+		return
+	}
+
+	code := "other"
+	var cliErr *Error
+	if errors.As(err, &cliErr) {
+		code = string(cliErr.Kind)
+	}
+	if m.failures == nil {
+		m.failures = make(map[string]int)
+	}
+	m.failures[code]++
+}
+
+type measured struct {
+	next Backend
+	m    *Metrics
+}
+
+func (b *measured) ExecuteRequest(ctx context.Context, req *Request) (*JSONResponse, error) {
+	return Collect(ctx, b, req)
+}
+
+func (b *measured) ExecuteStreamingRequest(ctx context.Context, req *Request, callback StreamCallback) error {
+	start := b.m.start()
+	err := b.next.ExecuteStreamingRequest(ctx, req, callback)
+	b.m.end(start, err)
+	return err
+}
@@ -0,0 +1,213 @@
+package claude
+
+import (
+	"cmp"
+	"encoding/json"
+	"strings"
+)
+
+// cliEvent is a line of the CLI's stream-json output
+type cliEvent struct {
+	Type    string `json:"type"`
+	Subtype string `json:"subtype,omitempty"`
+
+	// For init and result events
+	SessionID string `json:"session_id,omitempty"`
+
+	// For assistant and user message events
+	Message *cliMessage `json:"message,omitempty"`
+
+	// For stream_event wrappers (when using --include-partial-messages)
+	Event *cliStreamEvent `json:"event,omitempty"`
+
+	// For result events
+	ResultText   string  `json:"result,omitempty"`
+	CostUSD      float64 `json:"cost_usd,omitempty"`
+	TotalCostUSD float64 `json:"total_cost_usd,omitempty"`
+	IsError      bool    `json:"is_error,omitempty"`
+	DurationMS   int     `json:"duration_ms,omitempty"`
+	NumTurns     int     `json:"num_turns,omitempty"`
+	Usage        *Usage  `json:"usage,omitempty"`
+	StopReason   string  `json:"stop_reason,omitempty"`
+}
+
+// cliStreamEvent is the API event inside a stream_event wrapper
+type cliStreamEvent struct {
+	Type    string      `json:"type"`
+	Index   int         `json:"index,omitempty"`
+	Message *cliMessage `json:"message,omitempty"`
+
+	// For content_block_start
+	ContentBlock *cliBlock `json:"content_block,omitempty"`
+
+	// For content_block_delta and message_delta
+	Delta *cliDelta `json:"delta,omitempty"`
+
+	// For message_delta
+	Usage *Usage `json:"usage,omitempty"`
+}
+
+// cliMessage is a message of assistant, user and message_start events
+type cliMessage struct {
+	Content []cliBlock `json:"content,omitempty"`
+	Usage   *Usage     `json:"usage,omitempty"`
+}
+
+// cliBlock is a content block of a message
+type cliBlock struct {
+	Type string `json:"type"`
+
+	// For redacted_thinking blocks
+	Data string `json:"data,omitempty"`
+
+	// For tool_use blocks
+	ID    string         `json:"id,omitempty"`
+	Name  string         `json:"name,omitempty"`
+	Input map[string]any `json:"input,omitempty"`
+
+	// For tool_result blocks
+	ToolUseID string `json:"tool_use_id,omitempty"`
+	Content   any    `json:"content,omitempty"`
+	IsError   bool   `json:"is_error,omitempty"`
+}
+
+// cliDelta is the delta of content_block_delta and message_delta events
+type cliDelta struct {
+	Type      string `json:"type"`
+	Text      string `json:"text,omitempty"`
+	Thinking  string `json:"thinking,omitempty"`
+	Signature string `json:"signature,omitempty"`
+
+	// For message_delta
+	StopReason string `json:"stop_reason,omitempty"`
+}
+
+// parseEvent decodes a line of output. It reports false for lines that
+// aren't events.
+func parseEvent(line string) (*cliEvent, bool) {
+	if line == "" {
+		return nil, false
+	}
+	var event cliEvent
+	if err := json.Unmarshal([]byte(line), &event); err != nil {
+		return nil, false
+	}
+	return &event, true
+}
+
+// isError reports whether the event is a result that failed the run
+func (e *cliEvent) isError() bool {
+	return e.Type == "result" && isErrorResult(e.Subtype, e.IsError)
+}
+
+// events converts the event to backend events. Events that carry nothing
+// clients use convert to none.
+func (e *cliEvent) events() []*Event {
+	switch e.Type {
+	case "system":
+		if e.Subtype == "init" {
+			return []*Event{{Type: EventInit, SessionID: e.SessionID}}
+		}
+
+	case "stream_event":
+		if e.Event != nil {
+			if event := e.Event.event(); event != nil {
+				return []*Event{event}
+			}
+		}
+
+	case "assistant", "user":
+		if e.Message == nil {
+			return nil
+		}
+		var events []*Event
+		for _, block := range e.Message.Content {
+			switch block.Type {
+			case "tool_use":
+				events = append(events, &Event{Type: EventToolUse,
+					Tool: &ToolCall{ID: block.ID, Name: block.Name, Input: block.Input}})
+			case "tool_result":
+				events = append(events, &Event{Type: EventToolResult,
+					Tool: &ToolCall{ID: block.ToolUseID, Output: resultText(block.Content), IsError: block.IsError}})
+			}
+		}
+		return events
+
+	case "result":
+		return []*Event{{
+			Type:       EventResult,
+			SessionID:  e.SessionID,
+			Text:       e.ResultText,
+			StopReason: e.StopReason,
+			Usage:      e.Usage,
+			CostUSD:    cmp.Or(e.TotalCostUSD, e.CostUSD),
+			DurationMS: e.DurationMS,
+			NumTurns:   e.NumTurns,
+		}}
+	}
+	return nil
+}
+
+// event converts an API stream event, or returns nil for one clients
+// don't use
+func (e *cliStreamEvent) event() *Event {
+	switch e.Type {
+	case "message_start":
+		event := &Event{Type: EventMessageStart}
+		if e.Message != nil {
+			event.Usage = e.Message.Usage
+		}
+		return event
+
+	case "content_block_start":
+		if e.ContentBlock == nil {
+			return nil
+		}
+		return &Event{Type: EventBlockStart, Index: e.Index, Block: e.ContentBlock.Type, Text: e.ContentBlock.Data}
+
+	case "content_block_delta":
+		if e.Delta == nil {
+			return nil
+		}
+		switch e.Delta.Type {
+		case "text_delta":
+			return &Event{Type: EventText, Index: e.Index, Text: e.Delta.Text}
+		case "thinking_delta":
+			return &Event{Type: EventThinking, Index: e.Index, Text: e.Delta.Thinking}
+		case "signature_delta":
+			return &Event{Type: EventSignature, Index: e.Index, Text: e.Delta.Signature}
+		}
+
+	case "content_block_stop":
+		return &Event{Type: EventBlockStop, Index: e.Index}
+
+	case "message_delta":
+		event := &Event{Type: EventMessageDelta, Usage: e.Usage}
+		if e.Delta != nil {
+			event.StopReason = e.Delta.StopReason
+		}
+		return event
+	}
+	return nil
+}
+
+// resultText returns the text of a tool result, which is either a string or
+// a list of content blocks
+func resultText(content any) string {
+	switch c := content.(type) {
+	case string:
+		return c
+	case []any:
+		var b strings.Builder
+		for _, block := range c {
+			if m, ok := block.(map[string]any); ok {
+				if text, ok := m["text"].(string); ok {
+					b.WriteString(text)
+					b.WriteString("\n")
+				}
+			}
+		}
+		return b.String()
+	}
+	return ""
+}
@@ -0,0 +1,45 @@
+package claude
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCLIEvents(t *testing.T) {
+	usage := &Usage{InputTokens: 10, OutputTokens: 5}
+	tests := []struct {
+		line string
+		want []*Event
+	}{
+		{`{"type":"system","subtype":"init","session_id":"s1","tools":["Read"]}`,
+			[]*Event{{Type: EventInit, SessionID: "s1"}}},
+		{`{"type":"system","subtype":"compact_boundary"}`, nil},
+		{`{"type":"stream_event","event":{"type":"message_start","message":{"id":"msg_1","usage":{"input_tokens":10,"output_tokens":5}}}}`,
+			[]*Event{{Type: EventMessageStart, Usage: usage}}},
+		{`{"type":"stream_event","event":{"type":"content_block_start","index":1,"content_block":{"type":"redacted_thinking","data":"xyz"}}}`,
+			[]*Event{{Type: EventBlockStart, Index: 1, Block: "redacted_thinking", Text: "xyz"}}},
+		{`{"type":"stream_event","event":{"type":"content_block_delta","index":2,"delta":{"type":"text_delta","text":"Hi"}}}`,
+			[]*Event{{Type: EventText, Index: 2, Text: "Hi"}}},
+		{`{"type":"stream_event","event":{"type":"content_block_delta","index":0,"delta":{"type":"signature_delta","signature":"sig"}}}`,
+			[]*Event{{Type: EventSignature, Text: "sig"}}},
+		{`{"type":"stream_event","event":{"type":"content_block_delta","index":3,"delta":{"type":"input_json_delta","partial_json":"{"}}}`, nil},
+		{`{"type":"stream_event","event":{"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"input_tokens":10,"output_tokens":5}}}`,
+			[]*Event{{Type: EventMessageDelta, StopReason: "tool_use", Usage: usage}}},
+		{`{"type":"stream_event","event":{"type":"message_stop"}}`, nil},
+		{`{"type":"assistant","message":{"content":[{"type":"text","text":"Looking"},{"type":"tool_use","id":"t1","name":"WebSearch","input":{"query":"go"}}]}}`,
+			[]*Event{{Type: EventToolUse, Tool: &ToolCall{ID: "t1", Name: "WebSearch", Input: map[string]any{"query": "go"}}}}},
+		{`{"type":"user","message":{"content":[{"type":"tool_result","tool_use_id":"t1","content":[{"type":"text","text":"found"}],"is_error":true}]}}`,
+			[]*Event{{Type: EventToolResult, Tool: &ToolCall{ID: "t1", Output: "found\n", IsError: true}}}},
+		{`{"type":"result","subtype":"success","result":"Hi","session_id":"s1","cost_usd":0.1,"total_cost_usd":0.2,"num_turns":2,"usage":{"input_tokens":10,"output_tokens":5},"stop_reason":"end_turn"}`,
+			[]*Event{{Type: EventResult, SessionID: "s1", Text: "Hi", StopReason: "end_turn", Usage: usage, CostUSD: 0.2, NumTurns: 2}}},
+	}
+	for _, tt := range tests {
+		event, ok := parseEvent(tt.line)
+		if !ok {
+			t.Fatalf("parseEvent(%s) failed", tt.line)
+		}
+		if got := event.events(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("events of %s = %+v, want %+v", tt.line, got, tt.want)
+		}
+	}
+}
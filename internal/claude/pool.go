@@ -0,0 +1,541 @@
+package claude
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PoolConfig configures the pool of warm CLI processes
+type PoolConfig struct {
+	// Size is the most idle processes kept; zero disables the pool
+	Size int
+
+	// IdleTimeout stops processes that haven't been used for that long
+	IdleTimeout time.Duration
+
+	// MaxRequests is how many requests of a conversation a process
+	// serves before it is replaced; zero means no limit. With one, a
+	// process that has answered is always replaced by one resuming a
+	// fork of its session.
+	MaxRequests int
+
+	// ReapInterval is how often idle processes that exited or expired
+	// are dropped
+	ReapInterval time.Duration
+}
+
+// pool keeps CLI processes started in stream-json input mode, waiting on
+// stdin for their next message. A fresh process serves any request with
+// the same arguments, working directory and environment. A process that
+// has answered holds its session and is parked for the conversation's next
+// turn, which it continues in place, until it has served MaxRequests.
+// Then a process resuming a fork of the session is prepared instead.
+type pool struct {
+	path  string
+	grace time.Duration
+	cfg   PoolConfig
+
+	mu sync.Mutex
+	// idle processes, least recently used first
+	idle []*worker
+	// pending counts the processes being started for each spec key and
+	// session
+	pending map[string]int
+
+	// spares are empty workspaces of upcoming conversations, and template
+	// is the spec of the latest request that started one
+	spares   map[string]bool
+	template *procSpec
+}
+
+// procSpec describes how a pooled process is started. Processes with the
+// same key and session are interchangeable.
+type procSpec struct {
+	key  string
+	args []string
+	dir  string
+	env  []string
+
+	// session is the session the process resumes as a fork; empty for a
+	// new conversation
+	session string
+}
+
+func newSpec(args []string, dir string, env []string) procSpec {
+	key := strings.Join(args, "\x00") + "\x01" + dir + "\x01" + strings.Join(env, "\x00")
+	return procSpec{key: key, args: args, dir: dir, env: env}
+}
+
+// in returns the spec moved to another working directory
+func (s procSpec) in(dir string) procSpec {
+	return newSpec(s.args, dir, s.env)
+}
+
+// id identifies the interchangeable processes of the spec
+func (s procSpec) id() string {
+	return s.key + "\x02" + s.session
+}
+
+// worker is a pooled CLI process
+type worker struct {
+	key     string
+	dir     string
+	session string
+	idle    time.Time
+	// served counts the requests the process has answered
+	served int
+
+	cmd   *exec.Cmd
+	grace time.Duration
+	stdin io.WriteCloser
+	// lines receives the process's output and is closed when it ends
+	lines  chan string
+	quit   chan struct{}
+	exited chan struct{}
+	// err is the process's exit error, set before exited is closed
+	err  error
+	stop sync.Once
+
+	mu     sync.Mutex
+	stderr strings.Builder
+}
+
+// maxWorkerStderr caps the error output kept for one request
+const maxWorkerStderr = 64 * 1024
+
+func newPool(path string, grace time.Duration, cfg PoolConfig) *pool {
+	p := &pool{
+		path:    path,
+		grace:   grace,
+		cfg:     cfg,
+		pending: make(map[string]int),
+		spares:  make(map[string]bool),
+	}
+	go p.reap()
+	return p
+}
+
+// start launches a process for spec
+func (p *pool) start(spec procSpec) (*worker, error) {
+	cmd := exec.Command(p.path, spec.args...)
+	cmd.Dir = spec.dir
+	setProcessGroup(cmd)
+	if len(spec.env) > 0 {
+		cmd.Env = append(os.Environ(), spec.env...)
+	}
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	w := &worker{
+		key:     spec.key,
+		dir:     spec.dir,
+		session: spec.session,
+		cmd:     cmd,
+		grace:   p.grace,
+		stdin:   stdin,
+		lines:   make(chan string, 64),
+		quit:    make(chan struct{}),
+		exited:  make(chan struct{}),
+	}
+
+	stderrDone := make(chan struct{})
+	go func() {
+		defer close(stderrDone)
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			w.mu.Lock()
+			if w.stderr.Len() < maxWorkerStderr {
+				w.stderr.WriteString(scanner.Text())
+				w.stderr.WriteString("\n")
+			}
+			w.mu.Unlock()
+		}
+	}()
+
+	go func() {
+		scanner := bufio.NewScanner(stdout)
+		scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			if line := scanner.Text(); line != "" {
+				select {
+				case w.lines <- line:
+				case <-w.quit:
+				}
+			}
+		}
+		if scanner.Err() != nil {
+			cmd.Process.Kill()
+			io.Copy(io.Discard, stdout)
+		}
+		close(w.lines)
+		<-stderrDone
+		w.err = cmd.Wait()
+		signalGroup(cmd.Process, true)
+		close(w.exited)
+	}()
+	return w, nil
+}
+
+// alive reports whether the process is still running
+func (w *worker) alive() bool {
+	select {
+	case <-w.exited:
+		return false
+	default:
+		return true
+	}
+}
+
+// output returns the error output of the current request
+func (w *worker) output() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.stderr.String()
+}
+
+// kill stops a process in the middle of a request, with the processes it
+// started
+func (w *worker) kill() {
+	w.stopInput()
+	stopGroup(w.cmd.Process, w.grace)
+}
+
+// close ends the process's input so it exits, killing it if it doesn't
+// within the grace period
+func (w *worker) close() {
+	w.stopInput()
+	go func() {
+		select {
+		case <-w.exited:
+		case <-time.After(w.grace):
+			signalGroup(w.cmd.Process, true)
+		}
+	}()
+}
+
+// stopInput ends the process's input and stops passing on its output
+func (w *worker) stopInput() {
+	w.stop.Do(func() {
+		close(w.quit)
+		w.stdin.Close()
+	})
+}
+
+// run sends input to a process and passes its events to callback until the
+// result, which it returns the session of. The process is stopped if the
+// request fails or is stopped; otherwise the caller decides what follows.
+func (p *pool) run(ctx context.Context, w *worker, input []InputMessage, callback StreamCallback) (string, error) {
+	w.mu.Lock()
+	w.stderr.Reset()
+	w.mu.Unlock()
+
+	enc := json.NewEncoder(w.stdin)
+	for _, msg := range input {
+		if err := enc.Encode(msg); err != nil {
+			w.kill()
+			<-w.exited
+			return "", newError(ctx, cmp.Or(w.err, err), "", w.output())
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			w.kill()
+			return "", newError(ctx, ctx.Err(), "", "")
+
+		case line, ok := <-w.lines:
+			if !ok {
+				<-w.exited
+				if w.err != nil {
+					return "", newError(ctx, w.err, "", w.output())
+				}
+				return "", nil
+			}
+
+			event, ok := parseEvent(line)
+			if !ok {
+				// Skip lines that aren't valid JSON
+				continue
+			}
+
+			if event.isError() {
+				w.close()
+				return "", newError(ctx, nil, event.Subtype, event.ResultText)
+			}
+
+			if err := deliver(event, callback); err != nil {
+				w.kill()
+				if errors.Is(err, ErrStopStream) {
+					return "", nil
+				}
+				return "", err
+			}
+
+			if event.Type == "result" {
+				return event.SessionID, nil
+			}
+		}
+	}
+}
+
+// take removes an idle process for the spec key that holds session or
+// resumes a fork of it, or a fresh one if session is empty. It returns nil
+// if there is none.
+func (p *pool) take(key, session string) *worker {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for i := 0; i < len(p.idle); i++ {
+		w := p.idle[i]
+		if w.key != key || w.session != session {
+			continue
+		}
+		p.idle = append(p.idle[:i], p.idle[i+1:]...)
+		if w.alive() {
+			return w
+		}
+		i--
+	}
+	return nil
+}
+
+// add makes a process idle, stopping the least recently used one if the
+// pool is full. The caller holds p.mu.
+func (p *pool) add(w *worker) {
+	for len(p.idle) >= p.cfg.Size {
+		p.idle[0].close()
+		p.idle = p.idle[1:]
+	}
+	w.idle = time.Now()
+	p.idle = append(p.idle, w)
+}
+
+// replenish prepares a fresh process for the next request like one that
+// is starting. The workspace of a new conversation is used only once, so
+// for those the spare workspaces are prepared instead.
+func (p *pool) replenish(spec procSpec) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.spares[spec.dir] {
+		delete(p.spares, spec.dir)
+		template := spec.in("")
+		if p.template == nil || p.template.key != template.key {
+			p.template = &template
+			p.warmSpares()
+		}
+		return
+	}
+	p.warm(spec)
+}
+
+// finish follows up a process that has answered with a session, for the
+// next turn of its conversation. It is parked with the session while it may
+// serve more requests; otherwise it is stopped and a process resuming a
+// fork of the session, as described by fork, is prepared instead. Recent
+// conversations matter most, so the least recently used idle process makes
+// room either way.
+func (p *pool) finish(w *worker, fork procSpec) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	w.served++
+	if p.cfg.MaxRequests == 0 || w.served < p.cfg.MaxRequests {
+		w.session = fork.session
+		p.add(w)
+		return
+	}
+	w.close()
+	if len(p.idle) > 0 && len(p.idle)+p.starting() >= p.cfg.Size {
+		p.idle[0].close()
+		p.idle = p.idle[1:]
+	}
+	p.warm(fork)
+}
+
+// warm starts a process for spec in the background if there is none and
+// the pool has room. The caller holds p.mu.
+func (p *pool) warm(spec procSpec) {
+	id := spec.id()
+	if p.pending[id] > 0 || len(p.idle)+p.starting() >= p.cfg.Size {
+		return
+	}
+	for _, w := range p.idle {
+		if w.key == spec.key && w.session == spec.session {
+			return
+		}
+	}
+
+	p.pending[id]++
+	go func() {
+		w, err := p.start(spec)
+
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if p.pending[id]--; p.pending[id] == 0 {
+			delete(p.pending, id)
+		}
+		if err != nil {
+			log.Printf("Failed to start warm claude process: %v", err)
+			return
+		}
+		p.add(w)
+	}()
+}
+
+// starting counts the processes being started. The caller holds p.mu.
+func (p *pool) starting() int {
+	n := 0
+	for _, count := range p.pending {
+		n += count
+	}
+	return n
+}
+
+// warmSpares prepares a process for the template in each spare workspace,
+// replacing processes started for an earlier template. The caller holds
+// p.mu.
+func (p *pool) warmSpares() {
+	if p.template == nil {
+		return
+	}
+	for dir := range p.spares {
+		spec := p.template.in(dir)
+		p.discard(dir, func(w *worker) bool { return w.key != spec.key })
+		p.warm(spec)
+	}
+}
+
+// discard stops the idle processes in dir that match. The caller holds
+// p.mu.
+func (p *pool) discard(dir string, match func(*worker) bool) {
+	idle := p.idle[:0]
+	for _, w := range p.idle {
+		if w.dir == dir && match(w) {
+			w.close()
+			continue
+		}
+		idle = append(idle, w)
+	}
+	clear(p.idle[len(idle):])
+	p.idle = idle
+}
+
+// reap periodically drops idle processes that exited or have been idle too
+// long, and refills the spare workspaces
+func (p *pool) reap() {
+	ticker := time.NewTicker(p.cfg.ReapInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		p.mu.Lock()
+		idle := p.idle[:0]
+		for _, w := range p.idle {
+			switch {
+			case !w.alive():
+				log.Printf("Warm claude process exited: %v %s", w.err, strings.TrimSpace(w.output()))
+			case time.Since(w.idle) > p.cfg.IdleTimeout:
+				w.close()
+			default:
+				idle = append(idle, w)
+			}
+		}
+		clear(p.idle[len(idle):])
+		p.idle = idle
+		p.warmSpares()
+		p.mu.Unlock()
+	}
+}
+
+// runPooled runs a request on a warm process, starting one if none fits
+func (e *Executor) runPooled(ctx context.Context, req *Request, callback StreamCallback) error {
+	run := *req
+	run.Input = req.input()
+	env := req.env()
+
+	// A process that holds the session continues it without the resume
+	// flags, so it is found by the spec without them
+	base := run
+	base.ResumeSessionID, base.ForkSession = "", false
+	spec := newSpec(e.args(&base, "stream-json"), req.Dir, env)
+
+	// Resuming in place can only be done by a cold process
+	var w *worker
+	switch {
+	case req.ResumeSessionID == "":
+		w = e.pool.take(spec.key, "")
+		e.pool.replenish(spec)
+	case req.ForkSession:
+		w = e.pool.take(spec.key, req.ResumeSessionID)
+	}
+	if w == nil {
+		cold := newSpec(e.args(&run, "stream-json"), req.Dir, env)
+		cold.key = spec.key
+		var err error
+		if w, err = e.pool.start(cold); err != nil {
+			return newError(ctx, err, "", "")
+		}
+	}
+
+	session, err := e.pool.run(ctx, w, run.Input, callback)
+	if err != nil || session == "" {
+		w.close()
+		return err
+	}
+	next := base
+	next.ResumeSessionID, next.ForkSession = session, true
+	fork := newSpec(e.args(&next, "stream-json"), req.Dir, env)
+	fork.key, fork.session = spec.key, session
+	e.pool.finish(w, fork)
+	return nil
+}
+
+// Prewarm prepares a warm process in the empty workspace dir of an
+// upcoming conversation. Without a pool it does nothing.
+func (e *Executor) Prewarm(dir string) {
+	if e.pool == nil {
+		return
+	}
+	e.pool.mu.Lock()
+	defer e.pool.mu.Unlock()
+	e.pool.spares[dir] = true
+	if e.pool.template != nil {
+		e.pool.warm(e.pool.template.in(dir))
+	}
+}
+
+// Discard stops the idle processes in a workspace that is being removed
+func (e *Executor) Discard(dir string) {
+	if e.pool == nil {
+		return
+	}
+	e.pool.mu.Lock()
+	defer e.pool.mu.Unlock()
+	delete(e.pool.spares, dir)
+	e.pool.discard(dir, func(*worker) bool { return true })
+}
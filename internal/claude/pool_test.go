@@ -0,0 +1,250 @@
+package claude
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeCLI is a stand-in for the CLI in stream-json input mode. It answers
+// each line of input with "reply <n> from <pid>", or with an error result
+// if the line mentions fail, and logs when it starts and exits.
+const fakeCLI = `#!/bin/sh
+log="$(dirname "$0")/log"
+session=new-$$
+while [ $# -gt 0 ]; do
+	case $1 in
+	--resume) resumed=$2; session=$2; shift ;;
+	--fork-session) fork=1 ;;
+	esac
+	shift
+done
+[ -n "$fork" ] && session=fork-$$
+echo "start $$ $session $resumed" >> "$log"
+n=0
+while read -r line; do
+	n=$((n+1))
+	case $line in *fail*)
+		echo '{"type":"result","subtype":"error_during_execution","is_error":true,"result":"failed","session_id":"'$session'"}'
+		continue ;;
+	esac
+	text="reply $n from $$"
+	echo '{"type":"system","subtype":"init","session_id":"'$session'"}'
+	echo '{"type":"stream_event","event":{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"'"$text"'"}},"session_id":"'$session'"}'
+	echo '{"type":"result","subtype":"success","is_error":false,"result":"'"$text"'","session_id":"'$session'"}'
+done
+echo "exit $$" >> "$log"
+`
+
+// poolTest runs requests on an executor whose pool starts fakeCLI
+type poolTest struct {
+	t   *testing.T
+	e   *Executor
+	log string
+}
+
+func newPoolTest(t *testing.T, cfg PoolConfig) *poolTest {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake CLI is a shell script")
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "claude")
+	if err := os.WriteFile(path, []byte(fakeCLI), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ReapInterval == 0 {
+		cfg.ReapInterval = time.Hour
+	}
+	pt := &poolTest{t: t, e: NewExecutor(path, time.Second, cfg), log: filepath.Join(dir, "log")}
+	t.Cleanup(func() {
+		pt.waitFor("processes to start", func() bool {
+			pt.e.pool.mu.Lock()
+			defer pt.e.pool.mu.Unlock()
+			return pt.e.pool.starting() == 0
+		})
+		pt.e.pool.mu.Lock()
+		defer pt.e.pool.mu.Unlock()
+		for _, w := range pt.e.pool.idle {
+			w.kill()
+		}
+	})
+	return pt
+}
+
+// run sends prompt, resuming a fork of session if it is set, and returns
+// the reply and the session that holds it
+func (pt *poolTest) run(prompt, session string) (string, string, error) {
+	pt.t.Helper()
+	req := &Request{Prompt: prompt, ResumeSessionID: session, ForkSession: session != ""}
+	resp, err := pt.e.ExecuteRequest(context.Background(), req)
+	if err != nil {
+		return "", "", err
+	}
+	return resp.Result, resp.SessionID, nil
+}
+
+// mustRun is run for requests that must succeed
+func (pt *poolTest) mustRun(prompt, session string) (string, string) {
+	pt.t.Helper()
+	reply, session, err := pt.run(prompt, session)
+	if err != nil {
+		pt.t.Fatal(err)
+	}
+	return reply, session
+}
+
+// logged returns the lines the fake processes have logged so far
+func (pt *poolTest) logged() []string {
+	data, _ := os.ReadFile(pt.log)
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+// waitLogged waits until a logged line starts with prefix and returns it
+func (pt *poolTest) waitLogged(prefix string) string {
+	pt.t.Helper()
+	var line string
+	pt.waitFor("a log line starting "+prefix, func() bool {
+		for _, l := range pt.logged() {
+			if strings.HasPrefix(l, prefix) {
+				line = l
+				return true
+			}
+		}
+		return false
+	})
+	return line
+}
+
+// waitIdle waits until an idle process holds session or resumes a fork of
+// it, or until a fresh one is idle if session is empty
+func (pt *poolTest) waitIdle(session string) {
+	pt.t.Helper()
+	pt.waitFor("an idle process for session "+session, func() bool {
+		pt.e.pool.mu.Lock()
+		defer pt.e.pool.mu.Unlock()
+		for _, w := range pt.e.pool.idle {
+			if w.session == session {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// idle returns the process ids of the idle processes
+func (pt *poolTest) idle() []string {
+	pt.e.pool.mu.Lock()
+	defer pt.e.pool.mu.Unlock()
+	var pids []string
+	for _, w := range pt.e.pool.idle {
+		pids = append(pids, strconv.Itoa(w.cmd.Process.Pid))
+	}
+	return pids
+}
+
+func (pt *poolTest) waitFor(what string, cond func() bool) {
+	pt.t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			pt.t.Fatalf("timed out waiting for %s; log:\n%s", what, strings.Join(pt.logged(), "\n"))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// pid returns the process id a reply came from
+func pid(reply string) string {
+	_, pid, _ := strings.Cut(reply, " from ")
+	return pid
+}
+
+func TestPoolTakesWarmProcess(t *testing.T) {
+	pt := newPoolTest(t, PoolConfig{Size: 4, MaxRequests: 1})
+
+	reply, _ := pt.mustRun("hi", "")
+	if reply != "reply 1 from "+pid(reply) {
+		t.Fatalf("reply = %q, want the first reply of a process", reply)
+	}
+	// A fresh process is started for the next request like this one
+	pt.waitIdle("")
+
+	idle := pt.idle()
+	reply, _ = pt.mustRun("hello", "")
+	if !slices.Contains(idle, pid(reply)) {
+		t.Errorf("second request ran on %s, want one of the idle processes %v", pid(reply), idle)
+	}
+}
+
+func TestPoolForksAnsweredSession(t *testing.T) {
+	pt := newPoolTest(t, PoolConfig{Size: 4, MaxRequests: 1})
+
+	first, session := pt.mustRun("hi", "")
+	// The process has served its one request and is replaced by one
+	// resuming a fork of its session
+	pt.waitLogged("exit " + pid(first))
+	pt.waitIdle(session)
+
+	reply, next := pt.mustRun("again", session)
+	want := "start " + pid(reply) + " fork-" + pid(reply) + " " + session
+	if !slices.Contains(pt.logged(), want) || next != "fork-"+pid(reply) {
+		t.Errorf("follow-up = %q in %s, want it on the fork of %s started before it", reply, next, session)
+	}
+	if reply != "reply 1 from "+pid(reply) {
+		t.Errorf("follow-up = %q, want the fork's first reply", reply)
+	}
+}
+
+func TestPoolContinuesInPlace(t *testing.T) {
+	pt := newPoolTest(t, PoolConfig{Size: 4, MaxRequests: 2})
+
+	first, session := pt.mustRun("hi", "")
+	second, again := pt.mustRun("again", session)
+	if second != "reply 2 from "+pid(first) || again != session {
+		t.Fatalf("follow-up = %q in %s, want the second reply of %s in %s", second, again, pid(first), session)
+	}
+
+	// The process has served its limit, so a fork takes over
+	pt.waitLogged("exit " + pid(first))
+	pt.waitIdle(session)
+	third, next := pt.mustRun("once more", session)
+	if pid(third) == pid(first) || next != "fork-"+pid(third) {
+		t.Errorf("third request = %q in %s, want it on a fork of %s", third, next, session)
+	}
+}
+
+func TestPoolFailedRequest(t *testing.T) {
+	pt := newPoolTest(t, PoolConfig{Size: 4, MaxRequests: 0})
+
+	first, session := pt.mustRun("hi", "")
+	if _, _, err := pt.run("fail", session); err == nil {
+		t.Fatal("failed request returned no error")
+	}
+	// A process is never reused after a failed request
+	pt.waitLogged("exit " + pid(first))
+	reply, _ := pt.mustRun("again", session)
+	if pid(reply) == pid(first) {
+		t.Errorf("request after the failure ran on the failed process %s", pid(first))
+	}
+}
+
+func TestPoolReap(t *testing.T) {
+	pt := newPoolTest(t, PoolConfig{Size: 4, MaxRequests: 1,
+		IdleTimeout: 50 * time.Millisecond, ReapInterval: 10 * time.Millisecond})
+
+	pt.mustRun("hi", "")
+	pt.waitIdle("")
+	pt.waitFor("idle processes to expire", func() bool { return len(pt.idle()) == 0 })
+	for _, line := range pt.logged() {
+		if started, ok := strings.CutPrefix(line, "start "); ok {
+			pt.waitLogged("exit " + strings.Fields(started)[0])
+		}
+	}
+}
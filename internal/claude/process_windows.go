@@ -0,0 +1,26 @@
+//go:build windows
+
+package claude
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+)
+
+// setProcessGroup starts the command in a process group of its own, so the
+// tools and servers the CLI spawns can be stopped with it
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+}
+
+// signalGroup asks the process tree rooted at p to exit, or kills it.
+// Windows has no SIGTERM, so taskkill stands in for both signals.
+func signalGroup(p *os.Process, kill bool) error {
+	args := []string{"/T", "/PID", strconv.Itoa(p.Pid)}
+	if kill {
+		args = append([]string{"/F"}, args...)
+	}
+	return exec.Command("taskkill", args...).Run()
+}
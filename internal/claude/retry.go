@@ -0,0 +1,142 @@
+package claude
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand/v2"
+	"slices"
+	"sync/atomic"
+	"time"
+)
+
+// RetryPolicy controls how runs that fail before producing any output are
+// retried
+type RetryPolicy struct {
+	// MaxRetries is how many times a run is retried; zero disables retries
+	MaxRetries int
+
+	// BaseDelay is the backoff before the first retry. It doubles for each
+	// further retry up to MaxDelay, and each delay is jittered.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+
+	// Kinds are the kinds of failure worth retrying
+	Kinds []ErrorKind
+}
+
+// delay returns the jittered backoff before retry attempt, counted from 0
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	d := p.MaxDelay
+	if attempt < 30 {
+		d = min(p.BaseDelay<<attempt, p.MaxDelay)
+	}
+	if d <= 0 {
+		return 0
+	}
+	// Keep half the delay and randomize the rest, so clients that failed
+	// together don't retry together
+	return d/2 + rand.N(d/2+1)
+}
+
+// retryable reports whether a failed run of req may be retried. A run that
+// resumes a session and crashes most likely couldn't resume it, which a
+// retry won't fix, so it fails at once and the caller can replay the
+// conversation instead.
+func (p RetryPolicy) retryable(ctx context.Context, req *Request, err error, attempt int) bool {
+	var cliErr *Error
+	if attempt >= p.MaxRetries || ctx.Err() != nil || !errors.As(err, &cliErr) {
+		return false
+	}
+	if req.ResumeSessionID != "" && cliErr.Kind == ErrorCrash {
+		return false
+	}
+	return slices.Contains(p.Kinds, cliErr.Kind)
+}
+
+// RetryCounter counts the retried runs of a request
+type RetryCounter struct {
+	n atomic.Int64
+}
+
+type retryCounterKey struct{}
+
+// WithRetryCounter makes runs with the returned context count their
+// retries in c
+func WithRetryCounter(ctx context.Context, c *RetryCounter) context.Context {
+	return context.WithValue(ctx, retryCounterKey{}, c)
+}
+
+// Count returns the number of retries so far
+func (c *RetryCounter) Count() int {
+	if c == nil {
+		return 0
+	}
+	return int(c.n.Load())
+}
+
+// Retry retries runs that fail before they have produced any output,
+// under policy
+func Retry(policy RetryPolicy) Decorator {
+	return func(next Backend) Backend {
+		return &retrying{next: next, policy: policy}
+	}
+}
+
+type retrying struct {
+	next   Backend
+	policy RetryPolicy
+}
+
+func (r *retrying) ExecuteRequest(ctx context.Context, req *Request) (*JSONResponse, error) {
+	return Collect(ctx, r, req)
+}
+
+// ExecuteStreamingRequest runs a request, retrying it while it fails
+// before producing output. Init events carry no output, so they are held
+// back until the run's first other event.
+func (r *retrying) ExecuteStreamingRequest(ctx context.Context, req *Request, callback StreamCallback) error {
+	for attempt := 0; ; attempt++ {
+		var held []*Event
+		delivered := false
+		deliver := func() error {
+			delivered = true
+			for _, event := range held {
+				if err := callback(event); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		err := r.next.ExecuteStreamingRequest(ctx, req, func(event *Event) error {
+			if !delivered {
+				if event.Type == EventInit {
+					held = append(held, event)
+					return nil
+				}
+				if err := deliver(); err != nil {
+					return err
+				}
+			}
+			return callback(event)
+		})
+		if err == nil && !delivered {
+			err = deliver()
+		}
+		if delivered || !r.policy.retryable(ctx, req, err, attempt) {
+			return err
+		}
+
+		wait := r.policy.delay(attempt)
+		log.Printf("Claude run failed, retry %d of %d in %v: %v", attempt+1, r.policy.MaxRetries, wait, err)
+		if c, ok := ctx.Value(retryCounterKey{}).(*RetryCounter); ok {
+			c.n.Add(1)
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package claude
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRetryResumeCrash(t *testing.T) {
+	policy := RetryPolicy{MaxRetries: 2, Kinds: []ErrorKind{ErrorCrash, ErrorOverloaded}}
+	tests := []struct {
+		name    string
+		resume  string
+		message string
+		runs    int
+	}{
+		{"crash", "", "something went wrong", 3},
+		// A session that can't be resumed is replayed by the caller
+		// rather than retried
+		{"resume crash", "session-1", "No conversation found with session ID: session-1", 1},
+		{"resume overloaded", "session-1", "API Error: 529 Overloaded", 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fail := ErrorRun(tt.message)
+			fake := NewFake(fail, fail, fail)
+			backend := Wrap(fake, Retry(policy))
+
+			_, err := backend.ExecuteRequest(context.Background(), &Request{Prompt: "hi", ResumeSessionID: tt.resume})
+			if err == nil {
+				t.Fatal("failed run returned no error")
+			}
+			if n := len(fake.Requests()); n != tt.runs {
+				t.Errorf("ran %d times, want %d", n, tt.runs)
+			}
+		})
+	}
+}
@@ -1,69 +1,43 @@
 package claude
 
-// StreamEvent represents any event from Claude's stream-json output
-type StreamEvent struct {
-	Type    string `json:"type"`
-	Subtype string `json:"subtype,omitempty"`
-
-	// For init events
-	SessionID string `json:"session_id,omitempty"`
-	Tools     []any  `json:"tools,omitempty"`
-	Model     string `json:"model,omitempty"`
-
-	// For assistant message events
-	Message *AssistantMessage `json:"message,omitempty"`
-
-	// For stream_event wrapper (when using --include-partial-messages)
-	Event *InnerStreamEvent `json:"event,omitempty"`
-
-	// For result events
-	ResultText    string  `json:"result,omitempty"`
-	CostUSD       float64 `json:"cost_usd,omitempty"`
-	TotalCostUSD  float64 `json:"total_cost_usd,omitempty"`
-	IsError       bool    `json:"is_error,omitempty"`
-	DurationMS    int     `json:"duration_ms,omitempty"`
-	DurationAPIMS int     `json:"duration_api_ms,omitempty"`
-	NumTurns      int     `json:"num_turns,omitempty"`
-}
-
-// InnerStreamEvent represents the inner event from stream_event wrapper
-type InnerStreamEvent struct {
-	Type    string `json:"type"`
-	Index   int    `json:"index,omitempty"`
-	Message *AssistantMessage `json:"message,omitempty"`
-
-	// For content_block_start
-	ContentBlock *ContentBlock `json:"content_block,omitempty"`
-
-	// For content_block_delta
-	Delta *ContentDelta `json:"delta,omitempty"`
-}
-
-// AssistantMessage represents an assistant message in streaming
-type AssistantMessage struct {
-	ID      string `json:"id,omitempty"`
-	Type    string `json:"type,omitempty"`
-	Role    string `json:"role,omitempty"`
-	Content []any  `json:"content,omitempty"`
-	Model   string `json:"model,omitempty"`
-}
-
 // ContentBlock represents a content block
 type ContentBlock struct {
 	Type string `json:"type"`
 	Text string `json:"text,omitempty"`
 }
 
-// ContentDelta represents a delta in content
-type ContentDelta struct {
-	Type string `json:"type"`
-	Text string `json:"text,omitempty"`
+// InputMessage is a conversation turn sent to the CLI with
+// --input-format stream-json
+type InputMessage struct {
+	Type    string    `json:"type"`
+	Message InputTurn `json:"message"`
+}
+
+// InputTurn is the message of an InputMessage
+type InputTurn struct {
+	Role    string         `json:"role"`
+	Content []ContentBlock `json:"content"`
 }
 
 // Usage represents token usage in Claude response
 type Usage struct {
-	InputTokens  int `json:"input_tokens"`
-	OutputTokens int `json:"output_tokens"`
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens,omitempty"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens,omitempty"`
+}
+
+// Add adds the token counts of other to u
+func (u *Usage) Add(other *Usage) {
+	u.InputTokens += other.InputTokens
+	u.OutputTokens += other.OutputTokens
+	u.CacheCreationInputTokens += other.CacheCreationInputTokens
+	u.CacheReadInputTokens += other.CacheReadInputTokens
+}
+
+// TotalInputTokens returns all prompt tokens, including cached ones
+func (u *Usage) TotalInputTokens() int {
+	return u.InputTokens + u.CacheCreationInputTokens + u.CacheReadInputTokens
 }
 
 // JSONResponse represents a non-streaming JSON response from Claude
@@ -76,4 +50,15 @@ type JSONResponse struct {
 	NumTurns   int     `json:"num_turns,omitempty"`
 	Result     string  `json:"result,omitempty"`
 	SessionID  string  `json:"session_id,omitempty"`
+	Usage      *Usage  `json:"usage,omitempty"`
+
+	// StopReason is why the model stopped: "end_turn", "max_tokens",
+	// "stop_sequence", "tool_use" or "refusal"
+	StopReason string `json:"stop_reason,omitempty"`
+
+	// Thinking is the model's extended thinking, read from the stream
+	Thinking string `json:"-"`
+
+	// Sources are the pages the CLI's web tools turned up
+	Sources []WebSource `json:"-"`
 }
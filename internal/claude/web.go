@@ -0,0 +1,149 @@
+package claude
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// WebSource is a page the CLI read or found with its web tools
+type WebSource struct {
+	URL   string `json:"url"`
+	Title string `json:"title,omitempty"`
+
+	// Fetched is set for pages the CLI read with WebFetch, as opposed to
+	// search results it may not have looked at
+	Fetched bool `json:"fetched,omitempty"`
+}
+
+// ToolActivity reports a web tool call or its result, so clients can show
+// what the CLI is doing while it works
+type ToolActivity struct {
+	// Type is "tool_use" or "tool_result"
+	Type    string      `json:"type"`
+	Tool    string      `json:"tool"`
+	Query   string      `json:"query,omitempty"`
+	URL     string      `json:"url,omitempty"`
+	Sources []WebSource `json:"sources,omitempty"`
+	IsError bool        `json:"is_error,omitempty"`
+}
+
+// webCall is a pending WebFetch or WebSearch call
+type webCall struct {
+	tool  string
+	query string
+	url   string
+}
+
+// WebTracker follows the CLI's WebFetch and WebSearch calls through a
+// stream and collects the pages they turned up
+type WebTracker struct {
+	calls   map[string]webCall
+	sources []WebSource
+	index   map[string]int
+}
+
+// Observe records the web tool calls and results in an event and returns
+// them as activity
+func (t *WebTracker) Observe(event *Event) []ToolActivity {
+	if event.Tool == nil {
+		return nil
+	}
+	var a ToolActivity
+	var ok bool
+	switch event.Type {
+	case EventToolUse:
+		a, ok = t.use(event.Tool)
+	case EventToolResult:
+		a, ok = t.result(event.Tool)
+	}
+	if !ok {
+		return nil
+	}
+	return []ToolActivity{a}
+}
+
+func (t *WebTracker) use(tool *ToolCall) (ToolActivity, bool) {
+	if tool.Name != "WebFetch" && tool.Name != "WebSearch" {
+		return ToolActivity{}, false
+	}
+	call := webCall{tool: tool.Name}
+	call.query, _ = tool.Input["query"].(string)
+	call.url, _ = tool.Input["url"].(string)
+
+	if t.calls == nil {
+		t.calls = make(map[string]webCall)
+	}
+	t.calls[tool.ID] = call
+	return ToolActivity{Type: "tool_use", Tool: tool.Name, Query: call.query, URL: call.url}, true
+}
+
+func (t *WebTracker) result(tool *ToolCall) (ToolActivity, bool) {
+	call, ok := t.calls[tool.ID]
+	if !ok {
+		return ToolActivity{}, false
+	}
+	delete(t.calls, tool.ID)
+
+	activity := ToolActivity{Type: "tool_result", Tool: call.tool, Query: call.query, URL: call.url}
+	if tool.IsError {
+		activity.IsError = true
+		return activity, true
+	}
+
+	switch call.tool {
+	case "WebFetch":
+		if call.url != "" {
+			activity.Sources = []WebSource{{URL: call.url, Fetched: true}}
+		}
+	case "WebSearch":
+		activity.Sources = searchLinks(tool.Output)
+	}
+	for _, source := range activity.Sources {
+		t.add(source)
+	}
+	return activity, true
+}
+
+// add records a source, keeping the first title seen for a URL and
+// remembering if it was ever fetched
+func (t *WebTracker) add(source WebSource) {
+	if t.index == nil {
+		t.index = make(map[string]int)
+	}
+	if i, ok := t.index[source.URL]; ok {
+		t.sources[i].Fetched = t.sources[i].Fetched || source.Fetched
+		if t.sources[i].Title == "" {
+			t.sources[i].Title = source.Title
+		}
+		return
+	}
+	t.index[source.URL] = len(t.sources)
+	t.sources = append(t.sources, source)
+}
+
+// Sources returns the distinct pages seen so far, in order
+func (t *WebTracker) Sources() []WebSource {
+	return t.sources
+}
+
+// searchLinks extracts the results of a WebSearch call, which the CLI lists
+// as `Links: [{"title": ..., "url": ...}, ...]`
+func searchLinks(text string) []WebSource {
+	var sources []WebSource
+	for {
+		i := strings.Index(text, "Links: [")
+		if i < 0 {
+			return sources
+		}
+		text = text[i+len("Links: "):]
+
+		var links []WebSource
+		if err := json.NewDecoder(strings.NewReader(text)).Decode(&links); err == nil {
+			for _, link := range links {
+				if link.URL != "" {
+					sources = append(sources, WebSource{URL: link.URL, Title: link.Title})
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,390 @@
+package converter
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"claude-cli-as-openai-api/internal/anthropic"
+	"claude-cli-as-openai-api/internal/claude"
+	"claude-cli-as-openai-api/internal/openai"
+)
+
+// AnthropicToMessages converts an Anthropic Messages request into OpenAI
+// messages so it can share the prompt building path
+func AnthropicToMessages(req *anthropic.MessagesRequest) ([]openai.Message, error) {
+	var messages []openai.Message
+
+	if len(req.System) > 0 {
+		system, err := anthropicParts(req.System)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, openai.Message{Role: "system", Content: system})
+	}
+
+	for _, msg := range req.Messages {
+		switch msg.Role {
+		case "user":
+			// Tool results become separate tool messages, as in OpenAI
+			var rest anthropic.Content
+			for _, block := range msg.Content {
+				if block.Type != "tool_result" {
+					rest = append(rest, block)
+					continue
+				}
+				result, err := anthropicParts(block.Content)
+				if err != nil {
+					return nil, err
+				}
+				messages = append(messages, openai.Message{
+					Role:       "tool",
+					Content:    result,
+					ToolCallID: block.ToolUseID,
+				})
+			}
+			if len(rest) > 0 {
+				content, err := anthropicParts(rest)
+				if err != nil {
+					return nil, err
+				}
+				messages = append(messages, openai.Message{Role: "user", Content: content})
+			}
+
+		case "assistant":
+			var text []string
+			var calls []openai.ToolCall
+			for _, block := range msg.Content {
+				switch block.Type {
+				case "text":
+					text = append(text, block.Text)
+				case "tool_use":
+					args, err := json.Marshal(block.Input)
+					if err != nil {
+						return nil, fmt.Errorf("invalid tool_use input: %w", err)
+					}
+					calls = append(calls, openai.ToolCall{
+						ID:       block.ID,
+						Type:     "function",
+						Function: openai.FunctionCall{Name: block.Name, Arguments: string(args)},
+					})
+				}
+			}
+			messages = append(messages, openai.Message{
+				Role:      "assistant",
+				Content:   openai.TextContent(strings.Join(text, "")),
+				ToolCalls: calls,
+			})
+
+		default:
+			return nil, fmt.Errorf("invalid message role: %s", msg.Role)
+		}
+	}
+
+	return messages, nil
+}
+
+// anthropicParts converts content blocks to OpenAI content parts
+func anthropicParts(content anthropic.Content) (openai.MessageContent, error) {
+	parts := []openai.ContentPart{}
+	for _, block := range content {
+		switch block.Type {
+		case "text":
+			parts = append(parts, openai.ContentPart{Type: "text", Text: block.Text})
+
+		case "image":
+			url, err := sourceURL(block.Source)
+			if err != nil {
+				return openai.MessageContent{}, err
+			}
+			parts = append(parts, openai.ContentPart{
+				Type:     "image_url",
+				ImageURL: &openai.ImageURL{URL: url},
+			})
+
+		case "document":
+			if block.Source != nil && block.Source.Type == "text" {
+				parts = append(parts, openai.ContentPart{Type: "text", Text: block.Source.Data})
+				continue
+			}
+			url, err := sourceURL(block.Source)
+			if err != nil {
+				return openai.MessageContent{}, err
+			}
+			if block.Source.Type == "url" {
+				parts = append(parts, openai.ContentPart{Type: "text", Text: "[Document: " + url + "]"})
+				continue
+			}
+			parts = append(parts, openai.ContentPart{
+				Type: "file",
+				File: &openai.File{FileData: url},
+			})
+
+		default:
+			return openai.MessageContent{}, fmt.Errorf("unsupported content block type: %s", block.Type)
+		}
+	}
+	return openai.MessageContent{Parts: parts}, nil
+}
+
+func sourceURL(source *anthropic.Source) (string, error) {
+	if source == nil {
+		return "", fmt.Errorf("content block is missing source")
+	}
+	switch source.Type {
+	case "base64":
+		return fmt.Sprintf("data:%s;base64,%s", source.MediaType, source.Data), nil
+	case "url":
+		return source.URL, nil
+	default:
+		return "", fmt.Errorf("unsupported source type: %s", source.Type)
+	}
+}
+
+// ConvertAnthropicResponse converts a Claude JSON response to an Anthropic
+// Messages API response
+func ConvertAnthropicResponse(resp *claude.JSONResponse, messageID, model string, stopSequences []string) *anthropic.MessagesResponse {
+	stopReason := "end_turn"
+	if resp.StopReason != "" {
+		stopReason = resp.StopReason
+	}
+	var stopSequence *string
+
+	text, match := TruncateAtStop(resp.Result, stopSequences)
+	if match != "" {
+		stopReason = "stop_sequence"
+		stopSequence = &match
+	}
+
+	return &anthropic.MessagesResponse{
+		ID:           messageID,
+		Type:         "message",
+		Role:         "assistant",
+		Model:        model,
+		Content:      []anthropic.ContentBlock{{Type: "text", Text: text}},
+		StopReason:   &stopReason,
+		StopSequence: stopSequence,
+		Usage:        convertAnthropicUsage(resp.Usage),
+	}
+}
+
+// AnthropicEvent is a named server-sent event in the Messages API stream
+type AnthropicEvent struct {
+	Name string
+	Data json.RawMessage
+}
+
+// AnthropicStreamConverter converts backend events to Anthropic
+// server-sent events. A single run can span several model responses when
+// Claude uses its built-in tools, so the responses are merged into one
+// message and the built-in tool blocks are hidden. Text is cut at the
+// first of the request's stop sequences.
+type AnthropicStreamConverter struct {
+	messageID string
+	model     string
+	started   bool
+	finished  bool
+	nextIndex int
+	indexes   map[int]int
+	usage     usageTracker
+
+	// stopReason is that of the last model response, once it has ended
+	stopReason *string
+
+	// stop cuts the text, and textIndex is the merged index of the open
+	// text block, or -1
+	stop      *stopFilter
+	textIndex int
+}
+
+// NewAnthropicStreamConverter creates a new Anthropic stream converter
+func NewAnthropicStreamConverter(messageID, model string, stopSequences []string) *AnthropicStreamConverter {
+	c := &AnthropicStreamConverter{
+		messageID: messageID,
+		model:     model,
+		indexes:   make(map[int]int),
+		textIndex: -1,
+	}
+	if len(stopSequences) > 0 {
+		c.stop = &stopFilter{stops: stopSequences}
+	}
+	return c
+}
+
+// Stopped reports whether the text was cut at a stop sequence. The rest of
+// the run can then be discarded.
+func (c *AnthropicStreamConverter) Stopped() bool {
+	return c.stop != nil && c.stop.stopped
+}
+
+// ConvertEvent converts a backend event to Anthropic events
+func (c *AnthropicStreamConverter) ConvertEvent(event *claude.Event) []AnthropicEvent {
+	c.usage.observe(event)
+	if c.finished {
+		return nil
+	}
+
+	switch event.Type {
+	case claude.EventMessageStart:
+		// Block indexes restart with every model response
+		c.indexes = make(map[int]int)
+		if c.started {
+			return nil
+		}
+		c.started = true
+		return []AnthropicEvent{c.messageStart(event.Usage)}
+
+	case claude.EventBlockStart:
+		block := startBlock(event)
+		if block == nil {
+			c.indexes[event.Index] = -1
+			return nil
+		}
+		c.indexes[event.Index] = c.nextIndex
+		if event.Block == "text" {
+			c.textIndex = c.nextIndex
+		}
+		c.nextIndex++
+		return c.blockEvent("content_block_start", event.Index, "content_block", block)
+
+	case claude.EventText, claude.EventThinking, claude.EventSignature:
+		index, ok := c.indexes[event.Index]
+		if !ok || index < 0 {
+			return nil
+		}
+		var delta map[string]any
+		switch event.Type {
+		case claude.EventText:
+			if c.stop != nil && index == c.textIndex {
+				return c.text(event.Text)
+			}
+			delta = map[string]any{"type": "text_delta", "text": event.Text}
+		case claude.EventThinking:
+			delta = map[string]any{"type": "thinking_delta", "thinking": event.Text}
+		case claude.EventSignature:
+			delta = map[string]any{"type": "signature_delta", "signature": event.Text}
+		}
+		return c.blockEvent("content_block_delta", event.Index, "delta", delta)
+
+	case claude.EventBlockStop:
+		index, ok := c.indexes[event.Index]
+		if !ok || index < 0 {
+			return nil
+		}
+		var events []AnthropicEvent
+		if index == c.textIndex {
+			// Text held back for a possible stop sequence ends with its block
+			if c.stop != nil {
+				events = c.textDelta(c.stop.flush())
+			}
+			c.textIndex = -1
+		}
+		return append(events, c.blockEvent("content_block_stop", event.Index, "", nil)...)
+
+	case claude.EventMessageDelta:
+		// Only the final response's stop reason is meaningful
+		reason := event.StopReason
+		c.stopReason = &reason
+		return nil
+
+	case claude.EventResult:
+		var events []AnthropicEvent
+		if !c.started {
+			events = append(events, c.messageStart(nil))
+		}
+		if c.stopReason != nil {
+			events = append(events, c.messageDelta(*c.stopReason, nil))
+		}
+		return append(events, messageStop())
+	}
+
+	return nil
+}
+
+// text cuts the text of the open text block at a stop sequence. At a
+// match the block and the message are ended there.
+func (c *AnthropicStreamConverter) text(text string) []AnthropicEvent {
+	events := c.textDelta(c.stop.feed(text))
+	if !c.stop.stopped {
+		return events
+	}
+
+	c.finished = true
+	stopEvent, _ := json.Marshal(map[string]any{"type": "content_block_stop", "index": c.textIndex})
+	return append(events,
+		AnthropicEvent{Name: "content_block_stop", Data: stopEvent},
+		c.messageDelta("stop_sequence", &c.stop.match),
+		messageStop(),
+	)
+}
+
+// textDelta returns a delta of the open text block, or nothing for empty
+// text
+func (c *AnthropicStreamConverter) textDelta(text string) []AnthropicEvent {
+	if text == "" {
+		return nil
+	}
+	data, _ := json.Marshal(map[string]any{
+		"type":  "content_block_delta",
+		"index": c.textIndex,
+		"delta": map[string]any{"type": "text_delta", "text": text},
+	})
+	return []AnthropicEvent{{Name: "content_block_delta", Data: data}}
+}
+
+// blockEvent builds a content block event at the merged position of the
+// backend's block index, with value under key if key is set
+func (c *AnthropicStreamConverter) blockEvent(name string, index int, key string, value any) []AnthropicEvent {
+	fields := map[string]any{"type": name, "index": c.indexes[index]}
+	if key != "" {
+		fields[key] = value
+	}
+	data, _ := json.Marshal(fields)
+	return []AnthropicEvent{{Name: name, Data: data}}
+}
+
+// messageDelta ends the message with its stop reason and the usage totals
+// for all model responses
+func (c *AnthropicStreamConverter) messageDelta(stopReason string, stopSequence *string) AnthropicEvent {
+	data, _ := json.Marshal(map[string]any{
+		"type":  "message_delta",
+		"delta": map[string]any{"stop_reason": stopReason, "stop_sequence": stopSequence},
+		"usage": convertAnthropicUsage(c.usage.usage()),
+	})
+	return AnthropicEvent{Name: "message_delta", Data: data}
+}
+
+// messageStart opens the message with the usage of the first model
+// response's input, if known
+func (c *AnthropicStreamConverter) messageStart(usage *claude.Usage) AnthropicEvent {
+	data, _ := json.Marshal(map[string]any{
+		"type": "message_start",
+		"message": anthropic.MessagesResponse{
+			ID:      c.messageID,
+			Type:    "message",
+			Role:    "assistant",
+			Model:   c.model,
+			Content: []anthropic.ContentBlock{},
+			Usage:   convertAnthropicUsage(usage),
+		},
+	})
+	return AnthropicEvent{Name: "message_start", Data: data}
+}
+
+func messageStop() AnthropicEvent {
+	return AnthropicEvent{Name: "message_stop", Data: json.RawMessage(`{"type":"message_stop"}`)}
+}
+
+// startBlock returns the empty content block a block_start event opens,
+// or nil for the blocks of the CLI's built-in tools, which are internal
+func startBlock(event *claude.Event) map[string]any {
+	switch event.Block {
+	case "text":
+		return map[string]any{"type": "text", "text": ""}
+	case "thinking":
+		return map[string]any{"type": "thinking", "thinking": ""}
+	case "redacted_thinking":
+		return map[string]any{"type": "redacted_thinking", "data": event.Text}
+	}
+	return nil
+}
@@ -0,0 +1,263 @@
+package converter
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// maxAttachmentSize bounds the size of a single staged attachment
+const maxAttachmentSize = 32 << 20
+
+// Attachments stages images and files from message content parts as
+// temporary files the Claude CLI can read
+type Attachments struct {
+	allowLocalFiles bool
+	allowRemoteURLs bool
+	dir             string
+	count           int
+}
+
+// NewAttachments creates an empty attachment set. Local file references
+// (file:// URLs) are only honored when allowLocalFiles is set, and http(s)
+// URLs are only downloaded when allowRemoteURLs is set.
+func NewAttachments(allowLocalFiles, allowRemoteURLs bool) *Attachments {
+	return &Attachments{allowLocalFiles: allowLocalFiles, allowRemoteURLs: allowRemoteURLs}
+}
+
+// Dir returns the staging directory, or "" if nothing has been staged
+func (a *Attachments) Dir() string {
+	return a.dir
+}
+
+// Cleanup removes all staged files
+func (a *Attachments) Cleanup() error {
+	if a.dir == "" {
+		return nil
+	}
+	err := os.RemoveAll(a.dir)
+	a.dir = ""
+	return err
+}
+
+// stageURL stages an image or file referenced by a data:, file:// or
+// http(s) URL and returns its path
+func (a *Attachments) stageURL(ctx context.Context, rawURL, kind, filename string) (string, error) {
+	switch {
+	case strings.HasPrefix(rawURL, "data:"):
+		data, mediaType, err := decodeDataURL(rawURL)
+		if err != nil {
+			return "", err
+		}
+		return a.write(data, kind, filename, mediaType)
+
+	case strings.HasPrefix(rawURL, "file://"):
+		if !a.allowLocalFiles {
+			return "", fmt.Errorf("local file references are not allowed")
+		}
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			return "", fmt.Errorf("invalid file URL: %w", err)
+		}
+		data, err := readLimited(u.Path)
+		if err != nil {
+			return "", err
+		}
+		if filename == "" {
+			filename = filepath.Base(u.Path)
+		}
+		return a.write(data, kind, filename, "")
+
+	case strings.HasPrefix(rawURL, "http://"), strings.HasPrefix(rawURL, "https://"):
+		if !a.allowRemoteURLs {
+			return "", fmt.Errorf("remote %s URLs are not allowed; send the %s as a data URL", kind, kind)
+		}
+		data, mediaType, err := download(ctx, rawURL)
+		if err != nil {
+			return "", err
+		}
+		return a.write(data, kind, filename, mediaType)
+
+	default:
+		return "", fmt.Errorf("unsupported %s URL", kind)
+	}
+}
+
+// stageBase64 stages raw base64 file data, which may also be a data URL
+func (a *Attachments) stageBase64(data, kind, filename string) (string, error) {
+	if strings.HasPrefix(data, "data:") {
+		decoded, mediaType, err := decodeDataURL(data)
+		if err != nil {
+			return "", err
+		}
+		return a.write(decoded, kind, filename, mediaType)
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return "", fmt.Errorf("invalid base64 %s data: %w", kind, err)
+	}
+	return a.write(decoded, kind, filename, "")
+}
+
+func (a *Attachments) write(data []byte, kind, filename, mediaType string) (string, error) {
+	if len(data) > maxAttachmentSize {
+		return "", fmt.Errorf("%s exceeds %d bytes", kind, maxAttachmentSize)
+	}
+
+	if a.dir == "" {
+		dir, err := os.MkdirTemp("", "claude-attachments-")
+		if err != nil {
+			return "", fmt.Errorf("failed to create attachment directory: %w", err)
+		}
+		a.dir = dir
+	}
+
+	a.count++
+	name := fmt.Sprintf("%s-%d%s", kind, a.count, extension(filename, mediaType, data))
+	if filename != "" {
+		name = fmt.Sprintf("%d-%s", a.count, filepath.Base(filename))
+	}
+
+	path := filepath.Join(a.dir, name)
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return "", fmt.Errorf("failed to stage %s: %w", kind, err)
+	}
+	return path, nil
+}
+
+func decodeDataURL(dataURL string) ([]byte, string, error) {
+	header, payload, ok := strings.Cut(strings.TrimPrefix(dataURL, "data:"), ",")
+	if !ok {
+		return nil, "", fmt.Errorf("malformed data URL")
+	}
+
+	mediaType, params, _ := strings.Cut(header, ";")
+	if params != "base64" && !strings.HasSuffix(params, ";base64") {
+		decoded, err := url.PathUnescape(payload)
+		if err != nil {
+			return nil, "", fmt.Errorf("malformed data URL: %w", err)
+		}
+		return []byte(decoded), mediaType, nil
+	}
+
+	data, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid base64 in data URL: %w", err)
+	}
+	return data, mediaType, nil
+}
+
+// remoteClient downloads attachments. It only connects to public
+// addresses, checked after name resolution and for every redirect, so
+// clients can't make the server fetch from its own network.
+var remoteClient = &http.Client{
+	Timeout: 30 * time.Second,
+	Transport: &http.Transport{
+		// A proxy would make the connection checks see only the proxy
+		Proxy: nil,
+		DialContext: (&net.Dialer{
+			Timeout: 10 * time.Second,
+			Control: publicOnly,
+		}).DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 10 * time.Second,
+	},
+}
+
+// sharedAddressSpace is the carrier-grade NAT range, which IsPrivate
+// doesn't cover
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// publicOnly refuses connections to loopback, private, link-local and
+// other non-public addresses
+func publicOnly(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	ip = ip.Unmap()
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() || sharedAddressSpace.Contains(ip) {
+		return fmt.Errorf("address %s is not public", ip)
+	}
+	return nil
+}
+
+func download(ctx context.Context, rawURL string) ([]byte, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid URL: %w", err)
+	}
+
+	resp, err := remoteClient.Do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to download %s: %w", rawURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("failed to download %s: %s", rawURL, resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxAttachmentSize+1))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to download %s: %w", rawURL, err)
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return data, mediaType, nil
+}
+
+func readLimited(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open local file: %w", err)
+	}
+	defer f.Close()
+
+	return io.ReadAll(io.LimitReader(f, maxAttachmentSize+1))
+}
+
+// extension picks a file extension from the filename, media type, or the
+// sniffed content type, in that order
+func extension(filename, mediaType string, data []byte) string {
+	if ext := filepath.Ext(filename); ext != "" {
+		return ext
+	}
+	if mediaType == "" {
+		mediaType, _, _ = mime.ParseMediaType(http.DetectContentType(data))
+	}
+	switch mediaType {
+	case "image/jpeg":
+		return ".jpg"
+	case "image/png":
+		return ".png"
+	case "image/gif":
+		return ".gif"
+	case "image/webp":
+		return ".webp"
+	case "application/pdf":
+		return ".pdf"
+	case "text/plain":
+		return ".txt"
+	}
+	if exts, _ := mime.ExtensionsByType(mediaType); len(exts) > 0 {
+		return exts[0]
+	}
+	return ".bin"
+}
@@ -0,0 +1,38 @@
+package converter
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"claude-cli-as-openai-api/internal/claude"
+	"claude-cli-as-openai-api/internal/openai"
+)
+
+// Citations turns the pages the CLI read or found into url_citation
+// annotations on a reply. Fetched pages are always cited; search results
+// only when the reply mentions them. A citation covers the URL where the
+// reply quotes it and the whole reply otherwise.
+func Citations(content string, sources []claude.WebSource) []openai.Annotation {
+	var annotations []openai.Annotation
+	length := utf8.RuneCountInString(content)
+	for _, source := range sources {
+		start, end := 0, length
+		if i := strings.Index(content, source.URL); i >= 0 {
+			start = utf8.RuneCountInString(content[:i])
+			end = start + utf8.RuneCountInString(source.URL)
+		} else if !source.Fetched {
+			continue
+		}
+
+		annotations = append(annotations, openai.Annotation{
+			Type: "url_citation",
+			URLCitation: &openai.URLCitation{
+				StartIndex: start,
+				EndIndex:   end,
+				URL:        source.URL,
+				Title:      source.Title,
+			},
+		})
+	}
+	return annotations
+}
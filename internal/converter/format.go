@@ -0,0 +1,149 @@
+package converter
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"claude-cli-as-openai-api/internal/jsonschema"
+	"claude-cli-as-openai-api/internal/openai"
+)
+
+var schemaName = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// FormatError reports a reply that does not match the requested response
+// format. The model can be asked to correct it.
+type FormatError struct {
+	Reason string
+}
+
+func (e *FormatError) Error() string {
+	return "reply does not match the response format: " + e.Reason
+}
+
+// ResponseFormatEnabled reports whether the reply must be JSON
+func ResponseFormatEnabled(format *openai.ResponseFormat) bool {
+	return format != nil && format.Type != "" && format.Type != "text"
+}
+
+// ResponseFormatToPrompt validates a response format and renders the
+// instruction asking the model for JSON
+func ResponseFormatToPrompt(format *openai.ResponseFormat) (string, error) {
+	switch format.Type {
+	case "json_object":
+		return "[System: Reply with a single valid JSON object and nothing else. Do not wrap it in a code block.]", nil
+
+	case "json_schema":
+		spec := format.JSONSchema
+		if spec == nil {
+			return "", fmt.Errorf("response_format.json_schema is required for type json_schema")
+		}
+		if !schemaName.MatchString(spec.Name) {
+			return "", fmt.Errorf("response_format.json_schema.name must be 1-64 letters, digits, underscores or dashes")
+		}
+		if spec.Schema == nil {
+			return "", fmt.Errorf("response_format.json_schema.schema is required")
+		}
+		if err := jsonschema.Check(spec.Schema); err != nil {
+			return "", fmt.Errorf("invalid response_format schema: %w", err)
+		}
+		if spec.Strict != nil && *spec.Strict {
+			if err := checkStrictSchema(spec.Schema, "$"); err != nil {
+				return "", fmt.Errorf("invalid response_format schema for strict mode: %w", err)
+			}
+		}
+
+		schema, err := json.Marshal(spec.Schema)
+		if err != nil {
+			return "", fmt.Errorf("invalid response_format schema: %w", err)
+		}
+
+		var b strings.Builder
+		fmt.Fprintf(&b, "[System: Reply with a single JSON value that conforms to the JSON Schema %q below, and nothing else. Do not wrap it in a code block.", spec.Name)
+		if spec.Description != "" {
+			fmt.Fprintf(&b, "\nThe schema describes: %s", spec.Description)
+		}
+		fmt.Fprintf(&b, "\n%s]", schema)
+		return b.String(), nil
+
+	default:
+		return "", fmt.Errorf("invalid response_format type: %q", format.Type)
+	}
+}
+
+// CheckResponseFormat extracts the JSON reply from model output and
+// validates it against the response format. It returns the JSON to send to
+// the client, or a *FormatError.
+func CheckResponseFormat(format *openai.ResponseFormat, text string) (string, error) {
+	text = extractJSON(text)
+
+	var value any
+	if err := json.Unmarshal([]byte(text), &value); err != nil {
+		return "", &FormatError{Reason: "the reply is not valid JSON: " + err.Error()}
+	}
+
+	switch format.Type {
+	case "json_object":
+		if _, ok := value.(map[string]any); !ok {
+			return "", &FormatError{Reason: "the reply is not a JSON object"}
+		}
+	case "json_schema":
+		if err := jsonschema.Validate(format.JSONSchema.Schema, value); err != nil {
+			return "", &FormatError{Reason: err.Error()}
+		}
+	}
+	return text, nil
+}
+
+// ResponseFormatCorrection asks the model to fix a reply rejected by
+// CheckResponseFormat
+func ResponseFormatCorrection(err error) string {
+	return fmt.Sprintf("[System: Your previous reply was rejected: %v. Reply again with only the corrected JSON, following the same instructions.]", err)
+}
+
+// extractJSON strips whitespace and a surrounding code fence, which models
+// add despite being asked not to
+func extractJSON(text string) string {
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, "```") || !strings.HasSuffix(text, "```") || len(text) < 6 {
+		return text
+	}
+	text = strings.TrimSuffix(text, "```")
+	if i := strings.IndexByte(text, '\n'); i >= 0 {
+		text = text[i+1:]
+	} else {
+		text = strings.TrimPrefix(text, "```")
+	}
+	return strings.TrimSpace(text)
+}
+
+// checkStrictSchema applies the rules of OpenAI's strict mode: every object
+// must forbid additional properties and require all of its properties
+func checkStrictSchema(schema any, path string) error {
+	s, ok := schema.(map[string]any)
+	if !ok {
+		return nil
+	}
+
+	properties, hasProperties := s["properties"].(map[string]any)
+	if s["type"] == "object" || hasProperties {
+		if additional, ok := s["additionalProperties"].(bool); !ok || additional {
+			return fmt.Errorf("%s: additionalProperties must be false", path)
+		}
+		required, _ := s["required"].([]any)
+		for name := range properties {
+			if !slices.Contains(required, any(name)) {
+				return fmt.Errorf("%s: property %q must be listed in required", path, name)
+			}
+		}
+	}
+
+	for key, sub := range jsonschema.Subschemas(s) {
+		if err := checkStrictSchema(sub, path+"/"+key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package converter
+
+import (
+	"context"
+	"strings"
+
+	"claude-cli-as-openai-api/internal/claude"
+	"claude-cli-as-openai-api/internal/openai"
+)
+
+// MessagesToInput converts OpenAI messages to structured CLI input: a
+// single user turn, so the CLI runs the model once. The conversation up to
+// the last assistant reply is rendered as one block of context, as in
+// MessagesToPrompt, and each message after it gets a block of its own. The
+// system prompt is left out.
+func MessagesToInput(ctx context.Context, messages []openai.Message, attachments *Attachments) ([]claude.InputMessage, error) {
+	last := -1
+	for i, msg := range messages {
+		if msg.Role == "assistant" {
+			last = i
+		}
+	}
+
+	var history []string
+	var blocks []claude.ContentBlock
+	toolNames := make(map[string]string)
+	for i, msg := range messages {
+		if isSystem(msg) {
+			continue
+		}
+		text, err := renderMessage(ctx, msg, attachments, toolNames)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case text == "":
+		case i <= last:
+			history = append(history, text)
+		default:
+			blocks = append(blocks, claude.ContentBlock{Type: "text", Text: text})
+		}
+	}
+
+	if len(history) > 0 {
+		earlier := claude.ContentBlock{Type: "text", Text: strings.Join(history, "\n\n")}
+		blocks = append([]claude.ContentBlock{earlier}, blocks...)
+	}
+	if len(blocks) == 0 {
+		return nil, nil
+	}
+	return []claude.InputMessage{{
+		Type:    "user",
+		Message: claude.InputTurn{Role: "user", Content: blocks},
+	}}, nil
+}
+
+// WrapInput adds server instructions around structured input: before goes
+// at the start of its user turn and after at the end, where the text prompt
+// would have them
+func WrapInput(input []claude.InputMessage, before, after string) []claude.InputMessage {
+	if before == "" && after == "" {
+		return input
+	}
+	if len(input) == 0 {
+		input = []claude.InputMessage{{Type: "user", Message: claude.InputTurn{Role: "user"}}}
+	}
+	turn := &input[0].Message
+	if before != "" {
+		turn.Content = append([]claude.ContentBlock{{Type: "text", Text: before}}, turn.Content...)
+	}
+	if after != "" {
+		turn.Content = append(turn.Content, claude.ContentBlock{Type: "text", Text: after})
+	}
+	return input
+}
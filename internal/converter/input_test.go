@@ -0,0 +1,52 @@
+package converter
+
+import (
+	"context"
+	"testing"
+
+	"claude-cli-as-openai-api/internal/openai"
+)
+
+func TestMessagesToInput(t *testing.T) {
+	messages := []openai.Message{
+		{Role: "system", Content: openai.TextContent("Be brief.")},
+		{Role: "user", Content: openai.TextContent("Weather in Paris?")},
+		{Role: "assistant", ToolCalls: []openai.ToolCall{{ID: "call_1", Type: "function",
+			Function: openai.FunctionCall{Name: "get_weather", Arguments: `{"city":"Paris"}`}}}},
+		{Role: "tool", ToolCallID: "call_1", Content: openai.TextContent("sunny")},
+		{Role: "user", Name: "ann", Content: openai.TextContent("Thanks")},
+	}
+
+	input, err := MessagesToInput(context.Background(), messages, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The whole conversation is one turn, so the CLI runs the model once
+	if len(input) != 1 || input[0].Type != "user" || input[0].Message.Role != "user" {
+		t.Fatalf("input = %+v, want one user turn", input)
+	}
+
+	var texts []string
+	for _, block := range input[0].Message.Content {
+		texts = append(texts, block.Text)
+	}
+	want := []string{
+		"Weather in Paris?\n\n[Previous assistant response: " + formatToolCall(messages[2].ToolCalls[0]) + "]",
+		"[Result of function get_weather (call call_1): sunny]",
+		"ann: Thanks",
+	}
+	if len(texts) != len(want) {
+		t.Fatalf("blocks = %q, want %q", texts, want)
+	}
+	for i := range want {
+		if texts[i] != want[i] {
+			t.Errorf("block %d = %q, want %q", i, texts[i], want[i])
+		}
+	}
+
+	wrapped := WrapInput(input, "before", "after")
+	content := wrapped[0].Message.Content
+	if content[0].Text != "before" || content[len(content)-1].Text != "after" {
+		t.Errorf("wrapped = %+v, want the instructions at both ends", content)
+	}
+}
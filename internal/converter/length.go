@@ -0,0 +1,41 @@
+package converter
+
+import "unicode/utf8"
+
+// charsPerToken is a rough estimate of the text covered by one token, used
+// to enforce max_tokens when the CLI does not cap the output itself
+const charsPerToken = 4
+
+// EstimateTokens estimates the number of tokens in text
+func EstimateTokens(text string) int {
+	return (utf8.RuneCountInString(text) + charsPerToken - 1) / charsPerToken
+}
+
+// lengthLimit cuts streamed text once it reaches an estimated token budget
+type lengthLimit struct {
+	remaining int
+	reached   bool
+}
+
+func newLengthLimit(maxTokens int) *lengthLimit {
+	return &lengthLimit{remaining: maxTokens * charsPerToken}
+}
+
+// feed returns the part of text that fits in the budget
+func (l *lengthLimit) feed(text string) string {
+	if l.reached {
+		return ""
+	}
+
+	n := 0
+	for i := range text {
+		if n == l.remaining {
+			l.reached = true
+			return text[:i]
+		}
+		n++
+	}
+	l.remaining -= n
+	l.reached = l.remaining == 0
+	return text
+}
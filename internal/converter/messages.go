@@ -1,32 +1,139 @@
 package converter
 
 import (
+	"context"
 	"fmt"
 	"strings"
 
 	"claude-cli-as-openai-api/internal/openai"
 )
 
-// MessagesToPrompt converts OpenAI messages to a Claude prompt string
-func MessagesToPrompt(messages []openai.Message) string {
+// isSystem reports whether a message instructs the model rather than
+// taking part in the conversation
+func isSystem(msg openai.Message) bool {
+	return msg.Role == "system" || msg.Role == "developer"
+}
+
+// SystemPrompt joins the system and developer messages, which are passed
+// to the CLI as its system prompt rather than as part of the conversation
+func SystemPrompt(ctx context.Context, messages []openai.Message, attachments *Attachments) (string, error) {
+	var parts []string
+	for _, msg := range messages {
+		if !isSystem(msg) {
+			continue
+		}
+		content, err := renderContent(ctx, msg.Content, attachments)
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, content)
+	}
+	return strings.Join(parts, "\n\n"), nil
+}
+
+// MessagesToPrompt converts OpenAI messages to a Claude prompt string,
+// leaving out the system prompt. Images and files in content parts are
+// staged in attachments and referenced by path.
+func MessagesToPrompt(ctx context.Context, messages []openai.Message, attachments *Attachments) (string, error) {
 	var parts []string
+	toolNames := make(map[string]string)
 
 	for _, msg := range messages {
-		switch msg.Role {
-		case "system":
-			parts = append(parts, fmt.Sprintf("[System: %s]", msg.Content))
-		case "user":
-			if msg.Name != "" {
-				parts = append(parts, fmt.Sprintf("%s: %s", msg.Name, msg.Content))
+		if isSystem(msg) {
+			continue
+		}
+		text, err := renderMessage(ctx, msg, attachments, toolNames)
+		if err != nil {
+			return "", err
+		}
+		if text != "" {
+			parts = append(parts, text)
+		}
+	}
+
+	return strings.Join(parts, "\n\n"), nil
+}
+
+// renderMessage renders a conversation message as prompt text. toolNames
+// maps the IDs of the tool calls seen so far to their functions, so tool
+// results can name them. Messages of other roles render as nothing.
+func renderMessage(ctx context.Context, msg openai.Message, attachments *Attachments, toolNames map[string]string) (string, error) {
+	content, err := renderContent(ctx, msg.Content, attachments)
+	if err != nil {
+		return "", err
+	}
+
+	switch msg.Role {
+	case "assistant":
+		text := content
+		for _, call := range msg.ToolCalls {
+			toolNames[call.ID] = call.Function.Name
+			text = strings.TrimSpace(text + "\n" + formatToolCall(call))
+		}
+		return fmt.Sprintf("[Previous assistant response: %s]", text), nil
+	case "tool":
+		name := toolNames[msg.ToolCallID]
+		if name == "" {
+			name = msg.Name
+		}
+		return fmt.Sprintf("[Result of function %s (call %s): %s]", name, msg.ToolCallID, content), nil
+	case "user":
+		if msg.Name != "" {
+			return fmt.Sprintf("%s: %s", msg.Name, content), nil
+		}
+		return content, nil
+	}
+	return "", nil
+}
+
+// renderContent flattens message content to text, staging any images and
+// files so the CLI can open them with its Read tool
+func renderContent(ctx context.Context, content openai.MessageContent, attachments *Attachments) (string, error) {
+	if content.Parts == nil {
+		return content.String(), nil
+	}
+
+	var texts []string
+	for _, part := range content.Parts {
+		switch part.Type {
+		case "text", "input_text":
+			texts = append(texts, part.Text)
+
+		case "image_url":
+			if part.ImageURL == nil || part.ImageURL.URL == "" {
+				return "", fmt.Errorf("image_url part is missing url")
+			}
+			path, err := attachments.stageURL(ctx, part.ImageURL.URL, "image", "")
+			if err != nil {
+				return "", err
+			}
+			texts = append(texts, fmt.Sprintf("[Image attached at %s]", path))
+
+		case "file":
+			if part.File == nil {
+				return "", fmt.Errorf("file part is missing file")
+			}
+			if part.File.FileID != "" && part.File.FileData == "" {
+				return "", fmt.Errorf("file_id references are not supported; send file_data instead")
+			}
+			var path string
+			var err error
+			if strings.HasPrefix(part.File.FileData, "file://") {
+				path, err = attachments.stageURL(ctx, part.File.FileData, "file", part.File.Filename)
 			} else {
-				parts = append(parts, msg.Content)
+				path, err = attachments.stageBase64(part.File.FileData, "file", part.File.Filename)
 			}
-		case "assistant":
-			parts = append(parts, fmt.Sprintf("[Previous assistant response: %s]", msg.Content))
+			if err != nil {
+				return "", err
+			}
+			texts = append(texts, fmt.Sprintf("[File attached at %s]", path))
+
+		default:
+			return "", fmt.Errorf("unsupported content part type: %s", part.Type)
 		}
 	}
 
-	return strings.Join(parts, "\n\n")
+	return strings.Join(texts, "\n"), nil
 }
 
 // PromptStringToPrompt handles the legacy completions API prompt field
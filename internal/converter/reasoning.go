@@ -0,0 +1,46 @@
+package converter
+
+import (
+	"fmt"
+
+	"claude-cli-as-openai-api/internal/claude"
+)
+
+// minThinkingTokens is the smallest thinking budget the API accepts
+const minThinkingTokens = 1024
+
+// minAnswerTokens is kept out of the thinking budget for the answer
+const minAnswerTokens = 1024
+
+// thinkingBudgets maps OpenAI reasoning efforts to extended thinking
+// budgets, in line with the CLI's "think", "think hard" and "ultrathink"
+var thinkingBudgets = map[string]int{
+	"minimal": minThinkingTokens,
+	"low":     4000,
+	"medium":  10000,
+	"high":    31999,
+}
+
+// ThinkingBudget converts a reasoning_effort to a thinking budget. Zero
+// leaves the CLI's default, and "none" turns thinking off. The budget
+// shares max_tokens with the answer, so it shrinks to leave the answer
+// minAnswerTokens, and thinking is turned off if it doesn't fit at all.
+func ThinkingBudget(effort string, maxTokens int) (int, error) {
+	switch effort {
+	case "":
+		return 0, nil
+	case "none":
+		return claude.ThinkingOff, nil
+	}
+	budget, ok := thinkingBudgets[effort]
+	if !ok {
+		return 0, fmt.Errorf("invalid reasoning_effort: %q", effort)
+	}
+	if maxTokens > 0 {
+		budget = min(budget, maxTokens-minAnswerTokens)
+	}
+	if budget < minThinkingTokens {
+		return claude.ThinkingOff, nil
+	}
+	return budget, nil
+}
@@ -0,0 +1,36 @@
+package converter
+
+import (
+	"testing"
+
+	"claude-cli-as-openai-api/internal/claude"
+)
+
+func TestThinkingBudget(t *testing.T) {
+	tests := []struct {
+		effort    string
+		maxTokens int
+		want      int
+	}{
+		{"", 0, 0},
+		{"none", 0, claude.ThinkingOff},
+		{"low", 0, 4000},
+		{"high", 0, 31999},
+		// The answer keeps at least minAnswerTokens of max_tokens
+		{"medium", 8000, 8000 - minAnswerTokens},
+		{"low", 100000, 4000},
+		{"minimal", 2*minThinkingTokens - 1, claude.ThinkingOff},
+		{"high", 1500, claude.ThinkingOff},
+	}
+
+	for _, tt := range tests {
+		got, err := ThinkingBudget(tt.effort, tt.maxTokens)
+		if err != nil || got != tt.want {
+			t.Errorf("ThinkingBudget(%q, %d) = %d, %v, want %d", tt.effort, tt.maxTokens, got, err, tt.want)
+		}
+	}
+
+	if _, err := ThinkingBudget("extreme", 0); err == nil {
+		t.Error("an unknown effort was accepted")
+	}
+}
@@ -0,0 +1,259 @@
+package converter
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"claude-cli-as-openai-api/internal/claude"
+	"claude-cli-as-openai-api/internal/openai"
+)
+
+// ResponseInputToMessages converts Responses API input into chat messages
+func ResponseInputToMessages(req *openai.ResponseRequest) ([]openai.Message, error) {
+	var messages []openai.Message
+
+	if req.Instructions != "" {
+		messages = append(messages, openai.Message{
+			Role:    "system",
+			Content: openai.TextContent(req.Instructions),
+		})
+	}
+
+	if req.Input.Items == nil {
+		return append(messages, openai.Message{
+			Role:    "user",
+			Content: openai.TextContent(req.Input.Text),
+		}), nil
+	}
+
+	for _, item := range req.Input.Items {
+		if item.Type != "" && item.Type != "message" {
+			return nil, fmt.Errorf("unsupported input item type: %s", item.Type)
+		}
+
+		role := item.Role
+		if role == "developer" {
+			role = "system"
+		}
+		switch role {
+		case "user", "assistant", "system":
+		default:
+			return nil, fmt.Errorf("invalid input item role: %s", item.Role)
+		}
+
+		content, err := responseParts(item.Content)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, openai.Message{Role: role, Content: content})
+	}
+
+	return messages, nil
+}
+
+// responseParts converts Responses API content parts to chat content parts
+func responseParts(content openai.ResponseContent) (openai.MessageContent, error) {
+	if content.Parts == nil {
+		return openai.TextContent(content.Text), nil
+	}
+
+	parts := []openai.ContentPart{}
+	for _, part := range content.Parts {
+		switch part.Type {
+		case "input_text", "output_text":
+			parts = append(parts, openai.ContentPart{Type: "text", Text: part.Text})
+		case "input_image":
+			if part.ImageURL == "" {
+				return openai.MessageContent{}, fmt.Errorf("input_image requires image_url")
+			}
+			parts = append(parts, openai.ContentPart{
+				Type:     "image_url",
+				ImageURL: &openai.ImageURL{URL: part.ImageURL, Detail: part.Detail},
+			})
+		case "input_file":
+			parts = append(parts, openai.ContentPart{
+				Type: "file",
+				File: &openai.File{FileID: part.FileID, FileData: part.FileData, Filename: part.Filename},
+			})
+		default:
+			return openai.MessageContent{}, fmt.Errorf("unsupported content part type: %s", part.Type)
+		}
+	}
+	return openai.MessageContent{Parts: parts}, nil
+}
+
+// NewResponse creates an in-progress Responses API object
+func NewResponse(responseID, model, previousResponseID string) *openai.Response {
+	resp := &openai.Response{
+		ID:        responseID,
+		Object:    "response",
+		CreatedAt: time.Now().Unix(),
+		Status:    "in_progress",
+		Model:     model,
+		Output:    []openai.ResponseOutputItem{},
+	}
+	if previousResponseID != "" {
+		resp.PreviousResponseID = &previousResponseID
+	}
+	return resp
+}
+
+// CompleteResponse fills in a response from a Claude JSON response
+func CompleteResponse(resp *openai.Response, result *claude.JSONResponse) {
+	resp.Status = "completed"
+	resp.Output = []openai.ResponseOutputItem{
+		outputMessage(resp.ID, "completed", result.Result),
+	}
+	resp.Usage = convertResponseUsage(result.Usage)
+}
+
+func outputMessage(responseID, status, text string) openai.ResponseOutputItem {
+	item := openai.ResponseOutputItem{
+		Type:    "message",
+		ID:      outputItemID(responseID),
+		Status:  status,
+		Role:    "assistant",
+		Content: []openai.ResponseOutputContent{},
+	}
+	if status == "completed" {
+		item.Content = append(item.Content, outputText(text))
+	}
+	return item
+}
+
+func outputItemID(responseID string) string {
+	return "msg_" + strings.TrimPrefix(responseID, "resp_")
+}
+
+func outputText(text string) openai.ResponseOutputContent {
+	return openai.ResponseOutputContent{
+		Type:        "output_text",
+		Text:        text,
+		Annotations: []any{},
+	}
+}
+
+// ResponseStreamConverter converts backend events to typed Responses
+// API streaming events
+type ResponseStreamConverter struct {
+	response  *openai.Response
+	sequence  int
+	started   bool
+	text      string
+	sessionID string
+	usage     usageTracker
+}
+
+// NewResponseStreamConverter creates a new Responses stream converter
+func NewResponseStreamConverter(response *openai.Response) *ResponseStreamConverter {
+	return &ResponseStreamConverter{response: response}
+}
+
+// SessionID returns the CLI session ID seen in the stream
+func (c *ResponseStreamConverter) SessionID() string {
+	return c.sessionID
+}
+
+// Start returns the events that open the stream
+func (c *ResponseStreamConverter) Start() []*openai.ResponseStreamEvent {
+	return []*openai.ResponseStreamEvent{
+		c.responseEvent("response.created"),
+		c.responseEvent("response.in_progress"),
+	}
+}
+
+// ConvertEvent converts a backend event to Responses streaming events
+func (c *ResponseStreamConverter) ConvertEvent(event *claude.Event) []*openai.ResponseStreamEvent {
+	if event.SessionID != "" {
+		c.sessionID = event.SessionID
+	}
+	c.usage.observe(event)
+
+	switch event.Type {
+	case claude.EventText:
+		events := c.open()
+		c.text += event.Text
+		delta := c.partEvent("response.output_text.delta")
+		delta.Delta = event.Text
+		return append(events, delta)
+
+	case claude.EventResult:
+		events := c.open()
+		item := outputMessage(c.response.ID, "completed", c.text)
+		c.response.Status = "completed"
+		c.response.Output = []openai.ResponseOutputItem{item}
+		c.response.Usage = convertResponseUsage(c.usage.usage())
+
+		textDone := c.partEvent("response.output_text.done")
+		textDone.Text = &item.Content[0].Text
+		partDone := c.partEvent("response.content_part.done")
+		partDone.Part = &item.Content[0]
+
+		return append(events,
+			textDone,
+			partDone,
+			c.itemEvent("response.output_item.done", item),
+			c.responseEvent("response.completed"),
+		)
+	}
+
+	return nil
+}
+
+// Fail marks the response as failed and returns the terminal event
+func (c *ResponseStreamConverter) Fail(code, message string) *openai.ResponseStreamEvent {
+	c.response.Status = "failed"
+	c.response.Error = &openai.ResponseError{Code: code, Message: message}
+	return c.responseEvent("response.failed")
+}
+
+// open emits the output item and content part events before the first delta
+func (c *ResponseStreamConverter) open() []*openai.ResponseStreamEvent {
+	if c.started {
+		return nil
+	}
+	c.started = true
+
+	itemAdded := c.itemEvent("response.output_item.added", outputMessage(c.response.ID, "in_progress", ""))
+	part := outputText("")
+	partAdded := c.partEvent("response.content_part.added")
+	partAdded.Part = &part
+	return []*openai.ResponseStreamEvent{itemAdded, partAdded}
+}
+
+func (c *ResponseStreamConverter) event(eventType string) *openai.ResponseStreamEvent {
+	e := &openai.ResponseStreamEvent{
+		Type:           eventType,
+		SequenceNumber: c.sequence,
+	}
+	c.sequence++
+	return e
+}
+
+// responseEvent builds an event carrying a snapshot of the whole response
+func (c *ResponseStreamConverter) responseEvent(eventType string) *openai.ResponseStreamEvent {
+	e := c.event(eventType)
+	snapshot := *c.response
+	e.Response = &snapshot
+	return e
+}
+
+// itemEvent builds an event for the single output message item
+func (c *ResponseStreamConverter) itemEvent(eventType string, item openai.ResponseOutputItem) *openai.ResponseStreamEvent {
+	outputIndex := 0
+	e := c.event(eventType)
+	e.OutputIndex = &outputIndex
+	e.Item = &item
+	return e
+}
+
+// partEvent builds an event for the single output text part
+func (c *ResponseStreamConverter) partEvent(eventType string) *openai.ResponseStreamEvent {
+	outputIndex, contentIndex := 0, 0
+	e := c.event(eventType)
+	e.ItemID = outputItemID(c.response.ID)
+	e.OutputIndex = &outputIndex
+	e.ContentIndex = &contentIndex
+	return e
+}
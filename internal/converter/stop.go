@@ -0,0 +1,106 @@
+package converter
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// findStop returns the position and value of the earliest stop sequence in
+// text, or -1 if none occurs
+func findStop(text string, stops []string) (int, string) {
+	pos, match := -1, ""
+	for _, stop := range stops {
+		if stop == "" {
+			continue
+		}
+		if i := strings.Index(text, stop); i >= 0 && (pos < 0 || i < pos) {
+			pos, match = i, stop
+		}
+	}
+	return pos, match
+}
+
+// TruncateAtStop cuts text at the earliest stop sequence. It returns the
+// truncated text and the matched sequence, or "" if none matched.
+func TruncateAtStop(text string, stops []string) (string, string) {
+	pos, match := findStop(text, stops)
+	if pos < 0 {
+		return text, ""
+	}
+	return text[:pos], match
+}
+
+// maxStopSequences is the number of stop sequences OpenAI accepts
+const maxStopSequences = 4
+
+// ParseStop reads the stop field of a request, which is a string or an
+// array of strings
+func ParseStop(stop any) ([]string, error) {
+	var stops []string
+	switch s := stop.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		stops = []string{s}
+	case []any:
+		for _, item := range s {
+			str, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("stop must be a string or an array of strings")
+			}
+			stops = append(stops, str)
+		}
+	default:
+		return nil, fmt.Errorf("stop must be a string or an array of strings")
+	}
+
+	if len(stops) > maxStopSequences {
+		return nil, fmt.Errorf("stop accepts at most %d sequences", maxStopSequences)
+	}
+	return slices.DeleteFunc(stops, func(s string) bool { return s == "" }), nil
+}
+
+// stopFilter finds stop sequences in streamed text. A sequence may be split
+// across chunks, so text that could start one is held back until the
+// following chunks decide it.
+type stopFilter struct {
+	stops   []string
+	pending string
+	stopped bool
+
+	// match is the stop sequence that matched
+	match string
+}
+
+// feed returns the text that is safe to emit. Once a stop sequence
+// matches, the text before it is returned and the rest is dropped.
+func (f *stopFilter) feed(text string) string {
+	if f.stopped {
+		return ""
+	}
+	f.pending += text
+
+	if pos, match := findStop(f.pending, f.stops); pos >= 0 {
+		out := f.pending[:pos]
+		f.pending = ""
+		f.stopped = true
+		f.match = match
+		return out
+	}
+
+	hold := 0
+	for _, stop := range f.stops {
+		hold = max(hold, partialSuffix(f.pending, stop))
+	}
+	out := f.pending[:len(f.pending)-hold]
+	f.pending = f.pending[len(out):]
+	return out
+}
+
+// flush returns the held back text at the end of the stream
+func (f *stopFilter) flush() string {
+	out := f.pending
+	f.pending = ""
+	return out
+}
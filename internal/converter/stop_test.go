@@ -0,0 +1,94 @@
+package converter
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestStopFilter(t *testing.T) {
+	tests := []struct {
+		name  string
+		stops []string
+		text  string
+		want  string
+		match string
+	}{
+		{"no match", []string{"END"}, "Hello, world.", "Hello, world.", ""},
+		{"match", []string{"END"}, "Hello END world", "Hello ", "END"},
+		{"match at start", []string{"Hello"}, "Hello world", "", "Hello"},
+		{"earliest of several", []string{"world", "lo"}, "Hello world", "Hel", "lo"},
+		{"partial match at the end", []string{"END"}, "Hello EN", "Hello EN", ""},
+		{"overlapping prefix", []string{"aab"}, "aaab", "a", "aab"},
+		{"multibyte", []string{"é!"}, "café!", "caf", "é!"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Every chunk size must give the same result, wherever a
+			// sequence is split
+			for size := 1; size <= len(tt.text); size++ {
+				f := &stopFilter{stops: tt.stops}
+				var got string
+				for i := 0; i < len(tt.text); i += size {
+					got += f.feed(tt.text[i:min(i+size, len(tt.text))])
+				}
+				got += f.flush()
+
+				if got != tt.want || f.match != tt.match || f.stopped != (tt.match != "") {
+					t.Fatalf("chunks of %d: got %q stopped at %q, want %q stopped at %q",
+						size, got, f.match, tt.want, tt.match)
+				}
+			}
+		})
+	}
+}
+
+func TestStopFilterHoldsBack(t *testing.T) {
+	f := &stopFilter{stops: []string{"STOP"}}
+	if got := f.feed("Hello ST"); got != "Hello " {
+		t.Errorf("feed() = %q, want the possible start of STOP held back", got)
+	}
+	if got := f.feed("AY"); got != "STAY" {
+		t.Errorf("feed() = %q, want the held text once it can't match", got)
+	}
+	if got := f.feed(" STOP and more"); got != " " || !f.stopped {
+		t.Errorf("feed() = %q stopped %v, want the text before STOP", got, f.stopped)
+	}
+	if got := f.feed("after"); got != "" {
+		t.Errorf("feed() = %q after stopping, want nothing", got)
+	}
+}
+
+func TestTruncateAtStop(t *testing.T) {
+	text, match := TruncateAtStop("one two three", []string{"", "three", "two"})
+	if text != "one " || match != "two" {
+		t.Errorf("TruncateAtStop() = %q, %q, want %q, %q", text, match, "one ", "two")
+	}
+	if text, match := TruncateAtStop("one", nil); text != "one" || match != "" {
+		t.Errorf("TruncateAtStop() = %q, %q, want the text unchanged", text, match)
+	}
+}
+
+func TestParseStop(t *testing.T) {
+	tests := []struct {
+		name    string
+		stop    any
+		want    []string
+		wantErr bool
+	}{
+		{"none", nil, nil, false},
+		{"string", "END", []string{"END"}, false},
+		{"array", []any{"a", "", "b"}, []string{"a", "b"}, false},
+		{"not a string", []any{"a", 1}, nil, true},
+		{"too many", []any{"a", "b", "c", "d", "e"}, nil, true},
+		{"wrong type", 1.0, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseStop(tt.stop)
+			if (err != nil) != tt.wantErr || !slices.Equal(got, tt.want) {
+				t.Errorf("ParseStop() = %q, %v, want %q (error %v)", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
@@ -1,111 +1,354 @@
 package converter
 
 import (
+	"strings"
 	"time"
 
 	"claude-cli-as-openai-api/internal/claude"
 	"claude-cli-as-openai-api/internal/openai"
 )
 
-// StreamConverter converts Claude stream events to OpenAI format
+// Option configures how Claude output is converted to OpenAI responses
+type Option func(*options)
+
+type options struct {
+	tools       bool
+	choiceIndex int
+	stop        []string
+	maxTokens   int
+}
+
+// WithTools enables parsing of tool calls from the model output
+func WithTools(enabled bool) Option {
+	return func(o *options) {
+		o.tools = enabled
+	}
+}
+
+// WithChoiceIndex sets the choice index of the converted output, for
+// requests that run one CLI invocation per choice
+func WithChoiceIndex(index int) Option {
+	return func(o *options) {
+		o.choiceIndex = index
+	}
+}
+
+// WithStop cuts the output at the first of the given stop sequences
+func WithStop(stops []string) Option {
+	return func(o *options) {
+		o.stop = stops
+	}
+}
+
+// WithMaxTokens cuts the output once it reaches an estimated maxTokens
+func WithMaxTokens(maxTokens int) Option {
+	return func(o *options) {
+		o.maxTokens = maxTokens
+	}
+}
+
+func newOptions(opts []Option) options {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// StreamConverter converts backend events to OpenAI format
 type StreamConverter struct {
-	requestID   string
-	model       string
-	created     int64
-	sentRole    bool
+	requestID string
+	model     string
+	created   int64
+	sentRole  bool
+	opts      options
+	tools     *toolCallParser
+	stop      *stopFilter
+	length    *lengthLimit
+	finished  bool
+
+	// stopReason is the Claude stop reason of the run, including
+	// "stop_sequence" and "max_tokens" when the output was cut here
+	stopReason string
+	usage      usageTracker
+	web        claude.WebTracker
+	activity   []claude.ToolActivity
+	sessionID  string
+	raw        strings.Builder
+	content    strings.Builder
+	reasoning  strings.Builder
+	toolCalls  []openai.ToolCall
 }
 
 // NewStreamConverter creates a new stream converter
-func NewStreamConverter(requestID, model string) *StreamConverter {
-	return &StreamConverter{
+func NewStreamConverter(requestID, model string, opts ...Option) *StreamConverter {
+	c := &StreamConverter{
 		requestID: requestID,
 		model:     model,
 		created:   time.Now().Unix(),
 		sentRole:  false,
+		opts:      newOptions(opts),
+	}
+	if c.opts.tools {
+		c.tools = newToolCallParser()
 	}
+	if len(c.opts.stop) > 0 {
+		c.stop = &stopFilter{stops: c.opts.stop}
+	}
+	if c.opts.maxTokens > 0 {
+		c.length = newLengthLimit(c.opts.maxTokens)
+	}
+	return c
 }
 
-// ConvertEvent converts a Claude stream event to an OpenAI stream response
+// ConvertEvent converts a backend event to OpenAI stream responses
 // Returns nil if the event should not produce output
-func (c *StreamConverter) ConvertEvent(event *claude.StreamEvent) *openai.ChatCompletionStreamResponse {
+func (c *StreamConverter) ConvertEvent(event *claude.Event) []*openai.ChatCompletionStreamResponse {
+	c.usage.observe(event)
+	c.activity = c.web.Observe(event)
+	if event.SessionID != "" {
+		c.sessionID = event.SessionID
+	}
+	if c.finished {
+		return nil
+	}
+
 	switch event.Type {
-	case "stream_event":
-		// Handle nested stream events from --include-partial-messages
-		if event.Event == nil {
+	case claude.EventMessageStart:
+		// Send role on first message
+		if !c.sentRole {
+			c.sentRole = true
+			return []*openai.ChatCompletionStreamResponse{
+				c.chunk(&openai.Delta{Role: "assistant"}, nil),
+			}
+		}
+
+	case claude.EventText:
+		return c.text(event.Text)
+
+	case claude.EventThinking:
+		if event.Text == "" {
 			return nil
 		}
-		return c.convertInnerEvent(event.Event)
+		c.reasoning.WriteString(event.Text)
+		return []*openai.ChatCompletionStreamResponse{
+			c.chunk(&openai.Delta{ReasoningContent: event.Text}, nil),
+		}
+
+	case claude.EventMessageDelta:
+		if event.StopReason != "" {
+			c.stopReason = event.StopReason
+		}
 
-	case "result":
+	case claude.EventResult:
 		// Final event with finish reason
-		finishReason := "stop"
-		return &openai.ChatCompletionStreamResponse{
-			ID:      c.requestID,
-			Object:  "chat.completion.chunk",
-			Created: c.created,
-			Model:   c.model,
-			Choices: []openai.Choice{
-				{
-					Index:        0,
-					Delta:        &openai.Delta{},
-					FinishReason: &finishReason,
-				},
-			},
+		var chunks []*openai.ChatCompletionStreamResponse
+		if c.stop != nil {
+			// The held back text didn't turn into a stop sequence, so it
+			// goes out as it is
+			chunks = c.emit(c.stop.flush(), "")
+		}
+		if c.finished {
+			return chunks
 		}
+		return append(chunks, c.finish()...)
 	}
 
 	return nil
 }
 
-func (c *StreamConverter) convertInnerEvent(event *claude.InnerStreamEvent) *openai.ChatCompletionStreamResponse {
-	switch event.Type {
-	case "message_start":
-		// Send role on first message
-		if !c.sentRole {
-			c.sentRole = true
-			return &openai.ChatCompletionStreamResponse{
-				ID:      c.requestID,
-				Object:  "chat.completion.chunk",
-				Created: c.created,
-				Model:   c.model,
-				Choices: []openai.Choice{
-					{
-						Index: 0,
-						Delta: &openai.Delta{
-							Role: "assistant",
-						},
-						FinishReason: nil,
-					},
-				},
-			}
+// text converts model output, cutting it at a stop sequence or the token
+// limit
+func (c *StreamConverter) text(text string) []*openai.ChatCompletionStreamResponse {
+	cut := ""
+	if c.stop != nil && !c.stop.stopped {
+		text = c.stop.feed(text)
+		if c.stop.stopped {
+			cut = "stop_sequence"
 		}
+	}
+	return c.emit(text, cut)
+}
 
-	case "content_block_delta":
-		if event.Delta != nil && event.Delta.Type == "text_delta" {
-			return &openai.ChatCompletionStreamResponse{
-				ID:      c.requestID,
-				Object:  "chat.completion.chunk",
-				Created: c.created,
-				Model:   c.model,
-				Choices: []openai.Choice{
-					{
-						Index: 0,
-						Delta: &openai.Delta{
-							Content: event.Delta.Text,
-						},
-						FinishReason: nil,
-					},
-				},
-			}
+// emit sends text that has passed the stop filter, cutting it at the token
+// limit. cut is the stop reason if the output was cut before it.
+func (c *StreamConverter) emit(text, cut string) []*openai.ChatCompletionStreamResponse {
+	if c.length != nil {
+		text = c.length.feed(text)
+		if c.length.reached {
+			cut = "max_tokens"
 		}
 	}
 
-	return nil
+	var chunks []*openai.ChatCompletionStreamResponse
+	if text != "" {
+		c.raw.WriteString(text)
+		if c.tools != nil {
+			chunks = c.deltaChunks(c.tools.feed(text))
+		} else {
+			chunks = append(chunks, c.chunk(&openai.Delta{Content: text}, nil))
+		}
+	}
+
+	if cut != "" {
+		c.stopReason = cut
+		chunks = append(chunks, c.finish()...)
+	}
+	return chunks
+}
+
+// finish ends the choice with its finish reason
+func (c *StreamConverter) finish() []*openai.ChatCompletionStreamResponse {
+	var chunks []*openai.ChatCompletionStreamResponse
+	toolCalls := false
+	if c.tools != nil {
+		chunks = c.deltaChunks(c.tools.flush())
+		toolCalls = c.tools.hasCalls()
+	}
+	c.finished = true
+	if annotations := Citations(c.content.String(), c.web.Sources()); len(annotations) > 0 {
+		chunks = append(chunks, c.chunk(&openai.Delta{Annotations: annotations}, nil))
+	}
+	reason := finishReason(c.stopReason, toolCalls)
+	return append(chunks, c.chunk(&openai.Delta{}, &reason))
+}
+
+func (c *StreamConverter) deltaChunks(deltas []openai.Delta) []*openai.ChatCompletionStreamResponse {
+	chunks := make([]*openai.ChatCompletionStreamResponse, 0, len(deltas))
+	for i := range deltas {
+		chunks = append(chunks, c.chunk(&deltas[i], nil))
+	}
+	return chunks
+}
+
+// Activity returns the web tool calls and results seen in the last event
+func (c *StreamConverter) Activity() []claude.ToolActivity {
+	return c.activity
+}
+
+// SessionID returns the CLI session ID seen in the stream
+func (c *StreamConverter) SessionID() string {
+	return c.sessionID
+}
+
+// Stopped reports whether the output was cut at a stop sequence or the
+// token limit. The rest of the run can then be discarded.
+func (c *StreamConverter) Stopped() bool {
+	return (c.stop != nil && c.stop.stopped) || (c.length != nil && c.length.reached)
+}
+
+// Result returns the run as a CLI JSON response, with the text cut at a
+// matched stop sequence
+func (c *StreamConverter) Result() *claude.JSONResponse {
+	return &claude.JSONResponse{
+		Type:       "result",
+		Result:     c.raw.String(),
+		SessionID:  c.sessionID,
+		Usage:      c.usage.usage(),
+		StopReason: c.stopReason,
+		Thinking:   c.reasoning.String(),
+		Sources:    c.web.Sources(),
+	}
+}
+
+// Message returns the assistant message streamed so far
+func (c *StreamConverter) Message() openai.Message {
+	return openai.Message{
+		Role:             "assistant",
+		Content:          openai.TextContent(strings.TrimSpace(c.content.String())),
+		ReasoningContent: c.reasoning.String(),
+		ToolCalls:        c.toolCalls,
+	}
+}
+
+// record accumulates a delta into the streamed message
+func (c *StreamConverter) record(delta *openai.Delta) {
+	c.content.WriteString(delta.Content)
+	for _, call := range delta.ToolCalls {
+		if call.ID != "" {
+			c.toolCalls = append(c.toolCalls, openai.ToolCall{
+				ID:       call.ID,
+				Type:     call.Type,
+				Function: openai.FunctionCall{Name: call.Function.Name},
+			})
+		}
+		if call.Index != nil && *call.Index < len(c.toolCalls) {
+			c.toolCalls[*call.Index].Function.Arguments += call.Function.Arguments
+		}
+	}
+}
+
+func (c *StreamConverter) chunk(delta *openai.Delta, finishReason *string) *openai.ChatCompletionStreamResponse {
+	c.record(delta)
+	return &openai.ChatCompletionStreamResponse{
+		ID:      c.requestID,
+		Object:  "chat.completion.chunk",
+		Created: c.created,
+		Model:   c.model,
+		Choices: []openai.Choice{
+			{
+				Index:        c.opts.choiceIndex,
+				Delta:        delta,
+				FinishReason: finishReason,
+			},
+		},
+	}
+}
+
+// Usage returns the token usage of the stream so far
+func (c *StreamConverter) Usage() *openai.Usage {
+	return ConvertUsage(c.usage.usage())
+}
+
+// UsageChunk builds the trailing chunk that carries usage and no choices,
+// as requested by stream_options.include_usage
+func (c *StreamConverter) UsageChunk(usage *openai.Usage) *openai.ChatCompletionStreamResponse {
+	return &openai.ChatCompletionStreamResponse{
+		ID:      c.requestID,
+		Object:  "chat.completion.chunk",
+		Created: c.created,
+		Model:   c.model,
+		Choices: []openai.Choice{},
+		Usage:   usage,
+	}
+}
+
+// finishReason maps a Claude stop reason to an OpenAI finish reason. The
+// CLI runs its own tools, so "tool_use" only ends a choice with tool calls
+// when the reply holds calls for the client.
+func finishReason(stopReason string, toolCalls bool) string {
+	switch {
+	case stopReason == "max_tokens":
+		return "length"
+	case stopReason == "refusal":
+		return "content_filter"
+	case toolCalls:
+		return "tool_calls"
+	default:
+		return "stop"
+	}
 }
 
 // ConvertFinalResponse converts a Claude JSON response to an OpenAI response
-func ConvertFinalResponse(resp *claude.JSONResponse, requestID, model string) *openai.ChatCompletionResponse {
-	finishReason := "stop"
+func ConvertFinalResponse(resp *claude.JSONResponse, requestID, model string, opts ...Option) *openai.ChatCompletionResponse {
+	o := newOptions(opts)
+	text, _ := TruncateAtStop(resp.Result, o.stop)
+	message := &openai.Message{
+		Role:             "assistant",
+		Content:          openai.TextContent(text),
+		ReasoningContent: resp.Thinking,
+	}
+
+	if o.tools {
+		content, calls := ParseToolCalls(text)
+		message.Content = openai.TextContent(content)
+		if len(calls) > 0 {
+			message.ToolCalls = calls
+		}
+	}
+	message.Annotations = Citations(message.Content.String(), resp.Sources)
+	reason := finishReason(resp.StopReason, len(message.ToolCalls) > 0)
+
 	return &openai.ChatCompletionResponse{
 		ID:      requestID,
 		Object:  "chat.completion",
@@ -113,20 +356,72 @@ func ConvertFinalResponse(resp *claude.JSONResponse, requestID, model string) *o
 		Model:   model,
 		Choices: []openai.Choice{
 			{
-				Index: 0,
-				Message: &openai.Message{
-					Role:    "assistant",
-					Content: resp.Result,
-				},
-				FinishReason: &finishReason,
+				Index:        o.choiceIndex,
+				Message:      message,
+				FinishReason: &reason,
 			},
 		},
+		Usage: ConvertUsage(resp.Usage),
+	}
+}
+
+// CombineResponses merges the responses of one CLI run per choice into a
+// single response, summing their usage
+func CombineResponses(responses []*openai.ChatCompletionResponse) *openai.ChatCompletionResponse {
+	combined := *responses[0]
+	combined.Choices = nil
+	var usage []*openai.Usage
+	for _, resp := range responses {
+		combined.Choices = append(combined.Choices, resp.Choices...)
+		usage = append(usage, resp.Usage)
 	}
+	combined.Usage = SumUsage(usage...)
+	return &combined
+}
+
+// ResponseToChunks replays a complete response as stream chunks, for
+// output that had to be validated before any of it could be sent
+func ResponseToChunks(resp *openai.ChatCompletionResponse, includeUsage bool) []*openai.ChatCompletionStreamResponse {
+	var chunks []*openai.ChatCompletionStreamResponse
+	newChunk := func(choice openai.Choice) *openai.ChatCompletionStreamResponse {
+		return &openai.ChatCompletionStreamResponse{
+			ID:      resp.ID,
+			Object:  "chat.completion.chunk",
+			Created: resp.Created,
+			Model:   resp.Model,
+			Choices: []openai.Choice{choice},
+		}
+	}
+
+	for _, choice := range resp.Choices {
+		delta := &openai.Delta{
+			Role:             "assistant",
+			Content:          choice.Message.Content.String(),
+			ReasoningContent: choice.Message.ReasoningContent,
+			Annotations:      choice.Message.Annotations,
+		}
+		for i, call := range choice.Message.ToolCalls {
+			call.Index = &i
+			delta.ToolCalls = append(delta.ToolCalls, call)
+		}
+		chunks = append(chunks,
+			newChunk(openai.Choice{Index: choice.Index, Delta: delta}),
+			newChunk(openai.Choice{Index: choice.Index, Delta: &openai.Delta{}, FinishReason: choice.FinishReason}),
+		)
+	}
+
+	if includeUsage {
+		usage := newChunk(openai.Choice{})
+		usage.Choices = []openai.Choice{}
+		usage.Usage = resp.Usage
+		chunks = append(chunks, usage)
+	}
+	return chunks
 }
 
 // ConvertToCompletionResponse converts a Claude response to a legacy completion response
 func ConvertToCompletionResponse(resp *claude.JSONResponse, requestID, model string) *openai.CompletionResponse {
-	finishReason := "stop"
+	reason := finishReason(resp.StopReason, false)
 	return &openai.CompletionResponse{
 		ID:      requestID,
 		Object:  "text_completion",
@@ -136,8 +431,55 @@ func ConvertToCompletionResponse(resp *claude.JSONResponse, requestID, model str
 			{
 				Index:        0,
 				Text:         resp.Result,
-				FinishReason: &finishReason,
+				FinishReason: &reason,
 			},
 		},
+		Usage: ConvertUsage(resp.Usage),
+	}
+}
+
+// CombineCompletionResponses merges legacy completion responses of one CLI
+// run per choice, numbering the choices in order
+func CombineCompletionResponses(responses []*openai.CompletionResponse) *openai.CompletionResponse {
+	combined := *responses[0]
+	combined.Choices = nil
+	var usage []*openai.Usage
+	for i, resp := range responses {
+		for _, choice := range resp.Choices {
+			choice.Index = i
+			combined.Choices = append(combined.Choices, choice)
+		}
+		usage = append(usage, resp.Usage)
+	}
+	combined.Usage = SumUsage(usage...)
+	return &combined
+}
+
+// ConvertToCompletionChunk converts a chat completion chunk to a legacy
+// completion chunk. Returns nil if the chunk carries nothing to send.
+func ConvertToCompletionChunk(chunk *openai.ChatCompletionStreamResponse) *openai.CompletionResponse {
+	legacy := &openai.CompletionResponse{
+		ID:      chunk.ID,
+		Object:  "text_completion",
+		Created: chunk.Created,
+		Model:   chunk.Model,
+		Choices: []openai.CompletionChoice{},
+		Usage:   chunk.Usage,
+	}
+
+	for _, choice := range chunk.Choices {
+		if choice.Delta == nil {
+			continue
+		}
+		legacy.Choices = append(legacy.Choices, openai.CompletionChoice{
+			Index:        choice.Index,
+			Text:         choice.Delta.Content,
+			FinishReason: choice.FinishReason,
+		})
+	}
+
+	if len(legacy.Choices) == 0 && legacy.Usage == nil {
+		return nil
 	}
+	return legacy
 }
@@ -0,0 +1,109 @@
+package converter
+
+import (
+	"strings"
+	"testing"
+
+	"claude-cli-as-openai-api/internal/claude"
+)
+
+// textEvents streams chunks of model text followed by the run's result
+func textEvents(chunks ...string) []*claude.Event {
+	var events []*claude.Event
+	for _, text := range chunks {
+		events = append(events, &claude.Event{Type: claude.EventText, Text: text})
+	}
+	return append(events, &claude.Event{Type: claude.EventResult,
+		Text: strings.Join(chunks, ""), StopReason: "end_turn"})
+}
+
+// convertStream runs events through c and returns the streamed content and
+// the finish reasons sent
+func convertStream(c *StreamConverter, events []*claude.Event) (string, []string) {
+	var content strings.Builder
+	var reasons []string
+	for _, event := range events {
+		for _, chunk := range c.ConvertEvent(event) {
+			for _, choice := range chunk.Choices {
+				content.WriteString(choice.Delta.Content)
+				if choice.FinishReason != nil {
+					reasons = append(reasons, *choice.FinishReason)
+				}
+			}
+		}
+	}
+	return content.String(), reasons
+}
+
+func TestStreamConverterStop(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunks []string
+		want   string
+		reason string
+	}{
+		{"no stop", []string{"Hello", " world"}, "Hello world", "stop"},
+		{"stop", []string{"Hello E", "ND world"}, "Hello ", "stop"},
+		// Text held back for a possible stop sequence is sent once the
+		// run ends without one
+		{"held back at the end", []string{"Hello E"}, "Hello E", "stop"},
+		{"held back across chunks", []string{"Hello ", "EN"}, "Hello EN", "stop"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewStreamConverter("chatcmpl-1", "sonnet", WithStop([]string{"END"}))
+			content, reasons := convertStream(c, textEvents(tt.chunks...))
+
+			if content != tt.want {
+				t.Errorf("streamed %q, want %q", content, tt.want)
+			}
+			if len(reasons) != 1 || reasons[0] != tt.reason {
+				t.Errorf("finish reasons = %q, want one %q", reasons, tt.reason)
+			}
+			if got := c.Message().Content.String(); got != strings.TrimSpace(tt.want) {
+				t.Errorf("Message() = %q, want %q", got, tt.want)
+			}
+			if got := c.Result().Result; got != tt.want {
+				t.Errorf("Result() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStreamConverterStopAndLength(t *testing.T) {
+	// Text released at the end still counts towards the token limit, and
+	// the choice finishes once
+	c := NewStreamConverter("chatcmpl-1", "sonnet", WithStop([]string{"END"}), WithMaxTokens(1))
+	content, reasons := convertStream(c, textEvents("abE", "ND"))
+	if content != "ab" || len(reasons) != 1 || reasons[0] != "stop" {
+		t.Errorf("streamed %q with %q, want %q with one stop", content, reasons, "ab")
+	}
+
+	c = NewStreamConverter("chatcmpl-1", "sonnet", WithStop([]string{"END"}), WithMaxTokens(1))
+	content, reasons = convertStream(c, textEvents("abcdE"))
+	if content != "abcd" || len(reasons) != 1 || reasons[0] != "length" {
+		t.Errorf("streamed %q with %q, want %q with one length", content, reasons, "abcd")
+	}
+}
+
+func TestStreamConverterStopWithTools(t *testing.T) {
+	c := NewStreamConverter("chatcmpl-1", "sonnet", WithStop([]string{"<stop>"}), WithTools(true))
+	convertStream(c, textEvents(`<tool_call name="a">{"x":1}</tool_call>`, "<st"))
+	msg := c.Message()
+	if len(msg.ToolCalls) != 1 || msg.ToolCalls[0].Function.Arguments != `{"x":1}` {
+		t.Errorf("tool calls = %+v, want one call", msg.ToolCalls)
+	}
+}
+
+func TestStreamConverterUsage(t *testing.T) {
+	// Usage is added up over every result of the run
+	c := NewStreamConverter("chatcmpl-1", "sonnet")
+	for _, tokens := range []int{3, 4} {
+		c.ConvertEvent(&claude.Event{Type: claude.EventResult,
+			Usage: &claude.Usage{InputTokens: 10, OutputTokens: tokens}})
+	}
+	if u := c.Usage(); u == nil || u.PromptTokens != 20 || u.CompletionTokens != 7 {
+		t.Errorf("usage = %+v, want 20 prompt and 7 completion tokens", u)
+	}
+}
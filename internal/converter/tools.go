@@ -0,0 +1,278 @@
+package converter
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"claude-cli-as-openai-api/internal/openai"
+)
+
+// The CLI has no notion of client-side tools, so tool definitions are
+// described in the prompt and the model is asked to emit calls using this
+// markup, which is parsed back out of its output.
+const (
+	toolCallOpen  = "<tool_call"
+	toolCallClose = "</tool_call>"
+)
+
+var toolCallHeader = regexp.MustCompile(`^<tool_call\s+name="([^"]+)"\s*>`)
+
+// ToolsEnabled reports whether tool definitions should be offered to the model
+func ToolsEnabled(tools []openai.Tool, toolChoice any) bool {
+	if len(tools) == 0 {
+		return false
+	}
+	choice, _ := toolChoice.(string)
+	return choice != "none"
+}
+
+// ToolsToPrompt renders tool definitions and calling instructions for the model
+func ToolsToPrompt(tools []openai.Tool, toolChoice any) (string, error) {
+	names := make(map[string]bool, len(tools))
+	var defs []string
+	for _, tool := range tools {
+		if tool.Type != "" && tool.Type != "function" {
+			return "", fmt.Errorf("unsupported tool type: %s", tool.Type)
+		}
+		if tool.Function.Name == "" {
+			return "", fmt.Errorf("tool function name is required")
+		}
+		names[tool.Function.Name] = true
+
+		def, err := json.Marshal(tool.Function)
+		if err != nil {
+			return "", fmt.Errorf("invalid tool %s: %w", tool.Function.Name, err)
+		}
+		defs = append(defs, string(def))
+	}
+
+	var instruction string
+	switch choice := toolChoice.(type) {
+	case nil:
+		instruction = "Call a function only when it is needed to answer."
+	case string:
+		switch choice {
+		case "auto":
+			instruction = "Call a function only when it is needed to answer."
+		case "required":
+			instruction = "You must call at least one function in this response."
+		default:
+			return "", fmt.Errorf("invalid tool_choice: %s", choice)
+		}
+	case map[string]any:
+		fn, _ := choice["function"].(map[string]any)
+		name, _ := fn["name"].(string)
+		if !names[name] {
+			return "", fmt.Errorf("tool_choice references unknown function: %q", name)
+		}
+		instruction = fmt.Sprintf("You must call the function %q in this response.", name)
+	default:
+		return "", fmt.Errorf("invalid tool_choice")
+	}
+
+	var b strings.Builder
+	b.WriteString("[System: You can call the following functions, described as JSON:\n")
+	for _, def := range defs {
+		b.WriteString(def)
+		b.WriteString("\n")
+	}
+	b.WriteString("\nTo call a function, reply with one block per call in exactly this format, where the body is a JSON object of arguments matching the function's parameters:\n")
+	b.WriteString("<tool_call name=\"FUNCTION_NAME\">\n{\"argument\": \"value\"}\n</tool_call>\n")
+	b.WriteString("Do not use your built-in tools to perform these functions. After the last tool_call block, stop and wait: the results will be sent in the next message. ")
+	b.WriteString(instruction)
+	b.WriteString("]")
+
+	return b.String(), nil
+}
+
+// ParseToolCalls splits model output into plain content and tool calls
+func ParseToolCalls(text string) (string, []openai.ToolCall) {
+	parser := newToolCallParser()
+	deltas := append(parser.feed(text), parser.flush()...)
+
+	var content strings.Builder
+	var calls []openai.ToolCall
+	for _, delta := range deltas {
+		content.WriteString(delta.Content)
+		for _, call := range delta.ToolCalls {
+			if call.ID != "" {
+				calls = append(calls, openai.ToolCall{
+					ID:       call.ID,
+					Type:     call.Type,
+					Function: openai.FunctionCall{Name: call.Function.Name},
+				})
+			}
+			calls[*call.Index].Function.Arguments += call.Function.Arguments
+		}
+	}
+
+	for i := range calls {
+		calls[i].Function.Arguments = strings.TrimSpace(calls[i].Function.Arguments)
+	}
+
+	return strings.TrimSpace(content.String()), calls
+}
+
+// toolCallParser incrementally extracts tool call blocks from streamed text.
+// Text that may be the start of a block is held back until it can be decided.
+type toolCallParser struct {
+	buf       strings.Builder
+	inCall    bool
+	calls     int
+	argsBegun bool
+	argsSent  bool
+}
+
+func newToolCallParser() *toolCallParser {
+	return &toolCallParser{}
+}
+
+// feed consumes a chunk of model output and returns the deltas it completes
+func (p *toolCallParser) feed(text string) []openai.Delta {
+	p.buf.WriteString(text)
+	pending := p.buf.String()
+	p.buf.Reset()
+
+	var deltas []openai.Delta
+	for pending != "" {
+		if !p.inCall {
+			i := strings.Index(pending, toolCallOpen)
+			if i < 0 {
+				keep := partialSuffix(pending, toolCallOpen)
+				deltas = p.appendContent(deltas, pending[:len(pending)-keep])
+				pending = pending[len(pending)-keep:]
+				break
+			}
+
+			deltas = p.appendContent(deltas, pending[:i])
+			pending = pending[i:]
+
+			end := strings.Index(pending, ">")
+			if end < 0 {
+				break
+			}
+			match := toolCallHeader.FindStringSubmatch(pending[:end+1])
+			if match == nil {
+				// Not our markup; pass the tag through as text
+				deltas = p.appendContent(deltas, pending[:end+1])
+				pending = pending[end+1:]
+				continue
+			}
+
+			index := p.calls
+			p.calls++
+			p.inCall = true
+			p.argsBegun = false
+			p.argsSent = false
+			deltas = append(deltas, openai.Delta{ToolCalls: []openai.ToolCall{{
+				Index:    &index,
+				ID:       newToolCallID(),
+				Type:     "function",
+				Function: openai.FunctionCall{Name: match[1]},
+			}}})
+			pending = pending[end+1:]
+			continue
+		}
+
+		if !p.argsBegun {
+			pending = strings.TrimLeft(pending, " \t\r\n")
+			if pending == "" {
+				break
+			}
+			p.argsBegun = true
+		}
+
+		i := strings.Index(pending, toolCallClose)
+		if i < 0 {
+			keep := partialSuffix(pending, toolCallClose)
+			body := pending[:len(pending)-keep]
+			trimmed := strings.TrimRight(body, " \t\r\n")
+			deltas = p.appendArguments(deltas, trimmed)
+			pending = body[len(trimmed):] + pending[len(pending)-keep:]
+			break
+		}
+
+		deltas = p.closeCall(p.appendArguments(deltas, strings.TrimRight(pending[:i], " \t\r\n")))
+		pending = pending[i+len(toolCallClose):]
+	}
+
+	p.buf.WriteString(pending)
+	return deltas
+}
+
+// flush returns whatever is still held back once the output has ended
+func (p *toolCallParser) flush() []openai.Delta {
+	pending := p.buf.String()
+	p.buf.Reset()
+
+	if p.inCall {
+		return p.closeCall(p.appendArguments(nil, strings.TrimSpace(pending)))
+	}
+	return p.appendContent(nil, pending)
+}
+
+// hasCalls reports whether any tool call has been emitted
+func (p *toolCallParser) hasCalls() bool {
+	return p.calls > 0
+}
+
+func (p *toolCallParser) appendContent(deltas []openai.Delta, text string) []openai.Delta {
+	// Anything the model writes after its tool calls is dropped, matching
+	// the OpenAI behavior of returning tool calls as the final output
+	if text == "" || p.calls > 0 {
+		return deltas
+	}
+	return append(deltas, openai.Delta{Content: text})
+}
+
+func (p *toolCallParser) appendArguments(deltas []openai.Delta, text string) []openai.Delta {
+	if text == "" {
+		return deltas
+	}
+	p.argsSent = true
+	index := p.calls - 1
+	return append(deltas, openai.Delta{ToolCalls: []openai.ToolCall{{
+		Index:    &index,
+		Function: openai.FunctionCall{Arguments: text},
+	}}})
+}
+
+// closeCall ends the current tool call, giving it empty arguments if the
+// model provided none
+func (p *toolCallParser) closeCall(deltas []openai.Delta) []openai.Delta {
+	p.inCall = false
+	if !p.argsSent {
+		deltas = p.appendArguments(deltas, "{}")
+	}
+	return deltas
+}
+
+// partialSuffix returns the length of the longest suffix of s that is a
+// proper prefix of marker
+func partialSuffix(s, marker string) int {
+	for n := min(len(marker)-1, len(s)); n > 0; n-- {
+		if strings.HasSuffix(s, marker[:n]) {
+			return n
+		}
+	}
+	return 0
+}
+
+func newToolCallID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return "call_" + hex.EncodeToString(b)
+}
+
+// formatToolCall renders a previous tool call in the markup the model uses
+func formatToolCall(call openai.ToolCall) string {
+	args := call.Function.Arguments
+	if args == "" {
+		args = "{}"
+	}
+	return fmt.Sprintf("<tool_call name=%q>\n%s\n</tool_call>", call.Function.Name, args)
+}
@@ -0,0 +1,114 @@
+package converter
+
+import (
+	"strings"
+	"testing"
+
+	"claude-cli-as-openai-api/internal/openai"
+)
+
+// assembled is the output of a toolCallParser put back together
+type assembled struct {
+	content string
+	calls   []openai.ToolCall
+}
+
+// parseChunks feeds text to a toolCallParser in chunks of size bytes and
+// assembles its deltas, checking that they are well formed
+func parseChunks(t *testing.T, text string, size int) assembled {
+	t.Helper()
+	p := newToolCallParser()
+	var deltas []openai.Delta
+	for i := 0; i < len(text); i += size {
+		deltas = append(deltas, p.feed(text[i:min(i+size, len(text))])...)
+	}
+	deltas = append(deltas, p.flush()...)
+
+	var out assembled
+	var content strings.Builder
+	for _, delta := range deltas {
+		content.WriteString(delta.Content)
+		for _, call := range delta.ToolCalls {
+			if call.Index == nil {
+				t.Fatalf("tool call delta without an index: %+v", call)
+			}
+			if call.ID != "" {
+				if *call.Index != len(out.calls) || call.Type != "function" {
+					t.Fatalf("call %d started with index %d", len(out.calls), *call.Index)
+				}
+				out.calls = append(out.calls, openai.ToolCall{ID: call.ID, Function: call.Function})
+				continue
+			}
+			if *call.Index != len(out.calls)-1 {
+				t.Fatalf("arguments for call %d while call %d is open", *call.Index, len(out.calls)-1)
+			}
+			out.calls[*call.Index].Function.Arguments += call.Function.Arguments
+		}
+	}
+	out.content = content.String()
+	return out
+}
+
+func TestToolCallParser(t *testing.T) {
+	type call struct{ name, args string }
+	tests := []struct {
+		name    string
+		text    string
+		content string
+		calls   []call
+	}{
+		{"plain text", "Hello, world.", "Hello, world.", nil},
+		{"angle brackets", "1 < 2 and <b>bold</b>", "1 < 2 and <b>bold</b>", nil},
+		{"unfinished marker", "see <tool", "see <tool", nil},
+		{"other tag", `<tool_calls> and <tool_call id="x">`, `<tool_calls> and <tool_call id="x">`, nil},
+		{"call", "Let me check.\n<tool_call name=\"get_weather\">\n{\"city\": \"Paris\"}\n</tool_call>",
+			"Let me check.\n", []call{{"get_weather", `{"city": "Paris"}`}}},
+		{"no arguments", `<tool_call name="now"></tool_call>`, "", []call{{"now", "{}"}}},
+		{"two calls", "<tool_call name=\"a\">{\"x\":1}</tool_call>\n<tool_call name=\"b\">{\"y\":\"</tool_call\"}</tool_call>",
+			"", []call{{"a", `{"x":1}`}, {"b", `{"y":"</tool_call"}`}}},
+		{"text after calls", "<tool_call name=\"a\">{}</tool_call>\nDone!", "", []call{{"a", "{}"}}},
+		{"unclosed call", "<tool_call name=\"a\">\n{\"x\":1}\n", "", []call{{"a", `{"x":1}`}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Every chunk size must give the same result, wherever the
+			// markup is split
+			for size := 1; size <= len(tt.text); size++ {
+				got := parseChunks(t, tt.text, size)
+				if got.content != tt.content {
+					t.Fatalf("chunks of %d: content = %q, want %q", size, got.content, tt.content)
+				}
+				if len(got.calls) != len(tt.calls) {
+					t.Fatalf("chunks of %d: got %d calls, want %d", size, len(got.calls), len(tt.calls))
+				}
+				for i, want := range tt.calls {
+					fn := got.calls[i].Function
+					if fn.Name != want.name || fn.Arguments != want.args {
+						t.Fatalf("chunks of %d: call %d = %s(%s), want %s(%s)",
+							size, i, fn.Name, fn.Arguments, want.name, want.args)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestParseToolCalls(t *testing.T) {
+	content, calls := ParseToolCalls("Sure.\n<tool_call name=\"search\">\n{\"q\": \"go\"}\n</tool_call>")
+	if content != "Sure." {
+		t.Errorf("content = %q, want %q", content, "Sure.")
+	}
+	if len(calls) != 1 || calls[0].Function.Name != "search" || calls[0].Function.Arguments != `{"q": "go"}` {
+		t.Fatalf("calls = %+v, want one search call", calls)
+	}
+	if calls[0].Index != nil || !strings.HasPrefix(calls[0].ID, "call_") {
+		t.Errorf("call = %+v, want an ID and no index", calls[0])
+	}
+
+	// A call rendered for the prompt parses back to itself
+	content, again := ParseToolCalls(formatToolCall(calls[0]))
+	if content != "" || len(again) != 1 || again[0].Function != calls[0].Function {
+		t.Errorf("round trip = %q %+v, want %+v", content, again, calls[0].Function)
+	}
+}
@@ -0,0 +1,121 @@
+package converter
+
+import (
+	"claude-cli-as-openai-api/internal/anthropic"
+	"claude-cli-as-openai-api/internal/claude"
+	"claude-cli-as-openai-api/internal/openai"
+)
+
+// ConvertUsage converts Claude token usage to OpenAI usage. Cache reads and
+// writes count as prompt tokens; cache reads are also reported as cached.
+func ConvertUsage(u *claude.Usage) *openai.Usage {
+	if u == nil {
+		return nil
+	}
+	prompt := u.TotalInputTokens()
+	return &openai.Usage{
+		PromptTokens:     prompt,
+		CompletionTokens: u.OutputTokens,
+		TotalTokens:      prompt + u.OutputTokens,
+		PromptTokensDetails: &openai.PromptTokensDetails{
+			CachedTokens: u.CacheReadInputTokens,
+		},
+		CompletionTokensDetails: &openai.CompletionTokensDetails{},
+	}
+}
+
+// SumUsage adds up the usage of several CLI runs. Missing usage is skipped;
+// the result is nil if no run reported any.
+func SumUsage(usages ...*openai.Usage) *openai.Usage {
+	var total *openai.Usage
+	for _, u := range usages {
+		if u == nil {
+			continue
+		}
+		if total == nil {
+			total = &openai.Usage{
+				PromptTokensDetails:     &openai.PromptTokensDetails{},
+				CompletionTokensDetails: &openai.CompletionTokensDetails{},
+			}
+		}
+		total.PromptTokens += u.PromptTokens
+		total.CompletionTokens += u.CompletionTokens
+		total.TotalTokens += u.TotalTokens
+		if u.PromptTokensDetails != nil {
+			total.PromptTokensDetails.CachedTokens += u.PromptTokensDetails.CachedTokens
+		}
+		if u.CompletionTokensDetails != nil {
+			total.CompletionTokensDetails.ReasoningTokens += u.CompletionTokensDetails.ReasoningTokens
+		}
+	}
+	return total
+}
+
+func convertResponseUsage(u *claude.Usage) *openai.ResponseUsage {
+	if u == nil {
+		return nil
+	}
+	input := u.TotalInputTokens()
+	return &openai.ResponseUsage{
+		InputTokens:        input,
+		InputTokensDetails: openai.PromptTokensDetails{CachedTokens: u.CacheReadInputTokens},
+		OutputTokens:       u.OutputTokens,
+		TotalTokens:        input + u.OutputTokens,
+	}
+}
+
+func convertAnthropicUsage(u *claude.Usage) anthropic.Usage {
+	if u == nil {
+		return anthropic.Usage{}
+	}
+	return anthropic.Usage{
+		InputTokens:              u.InputTokens,
+		OutputTokens:             u.OutputTokens,
+		CacheCreationInputTokens: u.CacheCreationInputTokens,
+		CacheReadInputTokens:     u.CacheReadInputTokens,
+	}
+}
+
+// usageTracker follows token usage through a stream. Result events carry
+// the totals of their run and are added up; if there are none, usage is
+// summed from the message_start and message_delta events of each model
+// response.
+type usageTracker struct {
+	final   *claude.Usage
+	total   claude.Usage
+	current claude.Usage
+}
+
+func (t *usageTracker) observe(event *claude.Event) {
+	if event.Usage == nil {
+		return
+	}
+	switch event.Type {
+	case claude.EventResult:
+		if t.final == nil {
+			t.final = &claude.Usage{}
+		}
+		t.final.Add(event.Usage)
+	case claude.EventMessageStart:
+		t.commit()
+		t.current = *event.Usage
+	case claude.EventMessageDelta:
+		// message_delta output counts are cumulative for the response
+		t.current.OutputTokens = event.Usage.OutputTokens
+	}
+}
+
+func (t *usageTracker) commit() {
+	t.total.Add(&t.current)
+	t.current = claude.Usage{}
+}
+
+// usage returns the best known usage for the stream so far
+func (t *usageTracker) usage() *claude.Usage {
+	if t.final != nil {
+		return t.final
+	}
+	t.commit()
+	total := t.total
+	return &total
+}
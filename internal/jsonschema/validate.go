@@ -0,0 +1,478 @@
+package jsonschema
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxRefDepth bounds $ref chains that don't consume any of the value, so a
+// schema referencing itself can't recurse forever
+const maxRefDepth = 32
+
+// maxEvaluations bounds the subschema evaluations of one Validate call.
+// Combinators over recursive references branch at every level, so the
+// depth limit alone still allows exponential work.
+const maxEvaluations = 100000
+
+// errTooComplex reports a validation that ran out of evaluations
+var errTooComplex = errors.New("schema is too complex to validate the value against")
+
+// ValidationError describes where a value fails its schema
+type ValidationError struct {
+	Path    string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Path + ": " + e.Message
+}
+
+// Validate checks a value decoded by encoding/json against a JSON Schema.
+// It supports the subset used for structured outputs: type, enum, const,
+// properties, required, additionalProperties, items, prefixItems, anyOf,
+// oneOf, allOf, not, $ref to local definitions, and the usual string,
+// number and array bounds.
+func Validate(schema, value any) error {
+	v := &validator{root: schema}
+	err := v.validate(schema, value, "$", 0)
+	// Combinators may have taken the exhausted budget for a mismatch, so
+	// the outcome is not trusted either way
+	if v.evaluations > maxEvaluations {
+		return errTooComplex
+	}
+	return err
+}
+
+type validator struct {
+	root        any
+	evaluations int
+}
+
+func (v *validator) validate(schema, value any, path string, depth int) error {
+	if v.evaluations++; v.evaluations > maxEvaluations {
+		return errTooComplex
+	}
+
+	s, ok := schema.(map[string]any)
+	if !ok {
+		if allowed, ok := schema.(bool); ok {
+			if !allowed {
+				return fail(path, "no value is allowed here")
+			}
+			return nil
+		}
+		return fmt.Errorf("invalid schema at %s", path)
+	}
+
+	if ref, ok := s["$ref"].(string); ok {
+		if depth >= maxRefDepth {
+			return fmt.Errorf("schema $ref %q nests too deeply", ref)
+		}
+		target, err := v.resolve(ref)
+		if err != nil {
+			return err
+		}
+		if err := v.validate(target, value, path, depth+1); err != nil {
+			return err
+		}
+	}
+
+	if t, ok := s["type"]; ok {
+		if err := checkType(t, value, path); err != nil {
+			return err
+		}
+	}
+
+	if enum, ok := s["enum"].([]any); ok {
+		if !contains(enum, value) {
+			return fail(path, fmt.Sprintf("must be one of %s", describe(enum)))
+		}
+	}
+	if c, ok := s["const"]; ok && !reflect.DeepEqual(c, value) {
+		return fail(path, fmt.Sprintf("must be %s", describe([]any{c})))
+	}
+
+	if err := v.combinators(s, value, path, depth); err != nil {
+		return err
+	}
+
+	switch val := value.(type) {
+	case map[string]any:
+		return v.object(s, val, path)
+	case []any:
+		return v.array(s, val, path)
+	case string:
+		return checkString(s, val, path)
+	case float64:
+		return checkNumber(s, val, path)
+	}
+	return nil
+}
+
+func (v *validator) combinators(s map[string]any, value any, path string, depth int) error {
+	if all, ok := s["allOf"].([]any); ok {
+		for _, sub := range all {
+			if err := v.validate(sub, value, path, depth); err != nil {
+				return err
+			}
+		}
+	}
+
+	if anyOf, ok := s["anyOf"].([]any); ok {
+		var first error
+		matched := false
+		for _, sub := range anyOf {
+			err := v.validate(sub, value, path, depth)
+			if err == nil {
+				matched = true
+				break
+			}
+			if first == nil {
+				first = err
+			}
+		}
+		if !matched {
+			return fail(path, fmt.Sprintf("does not match any allowed schema (first mismatch: %v)", first))
+		}
+	}
+
+	if oneOf, ok := s["oneOf"].([]any); ok {
+		matches := 0
+		for _, sub := range oneOf {
+			if v.validate(sub, value, path, depth) == nil {
+				matches++
+			}
+		}
+		if matches != 1 {
+			return fail(path, fmt.Sprintf("must match exactly one schema, matched %d", matches))
+		}
+	}
+
+	if not, ok := s["not"]; ok && v.validate(not, value, path, depth) == nil {
+		return fail(path, "matches a schema it must not match")
+	}
+	return nil
+}
+
+func (v *validator) object(s map[string]any, obj map[string]any, path string) error {
+	if required, ok := s["required"].([]any); ok {
+		for _, name := range required {
+			key, _ := name.(string)
+			if _, ok := obj[key]; !ok {
+				return fail(path, fmt.Sprintf("missing required property %q", key))
+			}
+		}
+	}
+
+	properties, _ := s["properties"].(map[string]any)
+	additional, hasAdditional := s["additionalProperties"]
+	for key, val := range obj {
+		childPath := path + "." + key
+		if sub, ok := properties[key]; ok {
+			if err := v.validate(sub, val, childPath, 0); err != nil {
+				return err
+			}
+			continue
+		}
+		if !hasAdditional {
+			continue
+		}
+		if allowed, ok := additional.(bool); ok && !allowed {
+			return fail(path, fmt.Sprintf("unexpected property %q", key))
+		}
+		if err := v.validate(additional, val, childPath, 0); err != nil {
+			return err
+		}
+	}
+
+	if n, ok := number(s, "minProperties"); ok && float64(len(obj)) < n {
+		return fail(path, fmt.Sprintf("must have at least %v properties", n))
+	}
+	if n, ok := number(s, "maxProperties"); ok && float64(len(obj)) > n {
+		return fail(path, fmt.Sprintf("must have at most %v properties", n))
+	}
+	return nil
+}
+
+func (v *validator) array(s map[string]any, arr []any, path string) error {
+	if n, ok := number(s, "minItems"); ok && float64(len(arr)) < n {
+		return fail(path, fmt.Sprintf("must have at least %v items", n))
+	}
+	if n, ok := number(s, "maxItems"); ok && float64(len(arr)) > n {
+		return fail(path, fmt.Sprintf("must have at most %v items", n))
+	}
+
+	prefix, _ := s["prefixItems"].([]any)
+	items, hasItems := s["items"]
+	for i, val := range arr {
+		childPath := path + "[" + strconv.Itoa(i) + "]"
+		var sub any
+		switch {
+		case i < len(prefix):
+			sub = prefix[i]
+		case hasItems:
+			sub = items
+		default:
+			continue
+		}
+		if err := v.validate(sub, val, childPath, 0); err != nil {
+			return err
+		}
+	}
+
+	if unique, _ := s["uniqueItems"].(bool); unique {
+		for i := range arr {
+			for j := i + 1; j < len(arr); j++ {
+				if reflect.DeepEqual(arr[i], arr[j]) {
+					return fail(path, "items must be unique")
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func checkString(s map[string]any, str string, path string) error {
+	length := float64(len([]rune(str)))
+	if n, ok := number(s, "minLength"); ok && length < n {
+		return fail(path, fmt.Sprintf("must be at least %v characters", n))
+	}
+	if n, ok := number(s, "maxLength"); ok && length > n {
+		return fail(path, fmt.Sprintf("must be at most %v characters", n))
+	}
+	if pattern, ok := s["pattern"].(string); ok {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid schema pattern %q: %w", pattern, err)
+		}
+		if !re.MatchString(str) {
+			return fail(path, fmt.Sprintf("must match pattern %q", pattern))
+		}
+	}
+	return nil
+}
+
+func checkNumber(s map[string]any, num float64, path string) error {
+	if n, ok := number(s, "minimum"); ok && num < n {
+		return fail(path, fmt.Sprintf("must be >= %v", n))
+	}
+	if n, ok := number(s, "maximum"); ok && num > n {
+		return fail(path, fmt.Sprintf("must be <= %v", n))
+	}
+	if n, ok := number(s, "exclusiveMinimum"); ok && num <= n {
+		return fail(path, fmt.Sprintf("must be > %v", n))
+	}
+	if n, ok := number(s, "exclusiveMaximum"); ok && num >= n {
+		return fail(path, fmt.Sprintf("must be < %v", n))
+	}
+	if n, ok := number(s, "multipleOf"); ok && n > 0 {
+		if q := num / n; math.Abs(q-math.Round(q)) > 1e-9 {
+			return fail(path, fmt.Sprintf("must be a multiple of %v", n))
+		}
+	}
+	return nil
+}
+
+func checkType(t, value any, path string) error {
+	var types []string
+	switch t := t.(type) {
+	case string:
+		types = []string{t}
+	case []any:
+		for _, name := range t {
+			if s, ok := name.(string); ok {
+				types = append(types, s)
+			}
+		}
+	}
+
+	for _, name := range types {
+		if isType(name, value) {
+			return nil
+		}
+	}
+	return fail(path, fmt.Sprintf("expected %s, got %s", strings.Join(types, " or "), typeOf(value)))
+}
+
+func isType(name string, value any) bool {
+	switch name {
+	case "null":
+		return value == nil
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "integer":
+		n, ok := value.(float64)
+		return ok && n == math.Trunc(n)
+	case "object":
+		_, ok := value.(map[string]any)
+		return ok
+	case "array":
+		_, ok := value.([]any)
+		return ok
+	}
+	return false
+}
+
+func typeOf(value any) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// resolve looks up a local reference such as "#/$defs/item"
+func (v *validator) resolve(ref string) (any, error) {
+	if ref == "#" {
+		return v.root, nil
+	}
+	pointer, ok := strings.CutPrefix(ref, "#/")
+	if !ok {
+		return nil, fmt.Errorf("unsupported schema $ref %q: only local references are supported", ref)
+	}
+
+	node := v.root
+	for _, token := range strings.Split(pointer, "/") {
+		token = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+		obj, ok := node.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("schema $ref %q not found", ref)
+		}
+		if node, ok = obj[token]; !ok {
+			return nil, fmt.Errorf("schema $ref %q not found", ref)
+		}
+	}
+	return node, nil
+}
+
+func number(s map[string]any, key string) (float64, bool) {
+	n, ok := s[key].(float64)
+	return n, ok
+}
+
+func contains(values []any, value any) bool {
+	for _, v := range values {
+		if reflect.DeepEqual(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func describe(values []any) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		data, _ := json.Marshal(v)
+		parts[i] = string(data)
+	}
+	return strings.Join(parts, ", ")
+}
+
+func fail(path, message string) error {
+	return &ValidationError{Path: path, Message: message}
+}
+
+// Check reports problems in a schema that would stop Validate from
+// checking values against it, such as unresolvable references, invalid
+// patterns or unknown types
+func Check(schema any) error {
+	v := &validator{root: schema}
+	return v.check(schema, "$")
+}
+
+func (v *validator) check(schema any, path string) error {
+	if _, ok := schema.(bool); ok {
+		return nil
+	}
+	s, ok := schema.(map[string]any)
+	if !ok {
+		return fmt.Errorf("invalid schema at %s: must be an object or boolean", path)
+	}
+
+	if ref, ok := s["$ref"].(string); ok {
+		if _, err := v.resolve(ref); err != nil {
+			return err
+		}
+	}
+	if pattern, ok := s["pattern"].(string); ok {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("invalid schema pattern %q at %s: %w", pattern, path, err)
+		}
+	}
+	if t, ok := s["type"]; ok {
+		names, _ := t.([]any)
+		if name, ok := t.(string); ok {
+			names = []any{name}
+		}
+		if len(names) == 0 {
+			return fmt.Errorf("invalid schema type at %s", path)
+		}
+		for _, name := range names {
+			if name, _ := name.(string); !isKnownType(name) {
+				return fmt.Errorf("invalid schema type %v at %s", name, path)
+			}
+		}
+	}
+
+	for key, sub := range Subschemas(s) {
+		if err := v.check(sub, path+"/"+key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isKnownType(name string) bool {
+	switch name {
+	case "null", "boolean", "string", "number", "integer", "object", "array":
+		return true
+	}
+	return false
+}
+
+// Subschemas returns the schemas nested directly in s, keyed by their
+// location
+func Subschemas(s map[string]any) map[string]any {
+	subs := make(map[string]any)
+	for _, key := range []string{"items", "additionalProperties", "not"} {
+		if sub, ok := s[key]; ok {
+			subs[key] = sub
+		}
+	}
+	for _, key := range []string{"prefixItems", "anyOf", "oneOf", "allOf"} {
+		list, _ := s[key].([]any)
+		for i, sub := range list {
+			subs[key+"/"+strconv.Itoa(i)] = sub
+		}
+	}
+	for _, key := range []string{"properties", "$defs", "definitions"} {
+		named, _ := s[key].(map[string]any)
+		for name, sub := range named {
+			subs[key+"/"+name] = sub
+		}
+	}
+	return subs
+}
@@ -0,0 +1,184 @@
+package jsonschema
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func decode(t *testing.T, s string) any {
+	t.Helper()
+	var v any
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		t.Fatalf("invalid JSON %s: %v", s, err)
+	}
+	return v
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		value  string
+		// path is where the value should fail; empty means it is valid
+		path string
+	}{
+		{"type match", `{"type":"string"}`, `"a"`, ""},
+		{"type mismatch", `{"type":"string"}`, `1`, "$"},
+		{"type list", `{"type":["string","null"]}`, `null`, ""},
+		{"integer", `{"type":"integer"}`, `2`, ""},
+		{"integer fraction", `{"type":"integer"}`, `2.5`, "$"},
+		{"boolean schema true", `true`, `{"a":1}`, ""},
+		{"boolean schema false", `false`, `1`, "$"},
+		{"enum", `{"enum":["a","b"]}`, `"b"`, ""},
+		{"enum mismatch", `{"enum":["a","b"]}`, `"c"`, "$"},
+		{"const", `{"const":{"a":1}}`, `{"a":1}`, ""},
+		{"const mismatch", `{"const":{"a":1}}`, `{"a":2}`, "$"},
+
+		{"required", `{"type":"object","required":["a"]}`, `{"a":1}`, ""},
+		{"required missing", `{"type":"object","required":["a"]}`, `{"b":1}`, "$"},
+		{"property", `{"properties":{"a":{"type":"number"}}}`, `{"a":"x"}`, "$.a"},
+		{"additional allowed", `{"properties":{"a":{}}}`, `{"b":1}`, ""},
+		{"additional false", `{"properties":{"a":{}},"additionalProperties":false}`, `{"b":1}`, "$"},
+		{"additional schema", `{"additionalProperties":{"type":"string"}}`, `{"b":1}`, "$.b"},
+		{"min properties", `{"minProperties":2}`, `{"a":1}`, "$"},
+		{"max properties", `{"maxProperties":1}`, `{"a":1,"b":2}`, "$"},
+
+		{"items", `{"items":{"type":"number"}}`, `[1,2,"x"]`, "$[2]"},
+		{"prefix items", `{"prefixItems":[{"type":"string"}],"items":{"type":"number"}}`, `["a",1]`, ""},
+		{"prefix items mismatch", `{"prefixItems":[{"type":"string"}]}`, `[1]`, "$[0]"},
+		{"min items", `{"minItems":1}`, `[]`, "$"},
+		{"max items", `{"maxItems":1}`, `[1,2]`, "$"},
+		{"unique items", `{"uniqueItems":true}`, `[1,2,1]`, "$"},
+
+		{"min length", `{"minLength":2}`, `"é"`, "$"},
+		{"max length counts runes", `{"maxLength":1}`, `"é"`, ""},
+		{"pattern", `{"pattern":"^a+$"}`, `"aaa"`, ""},
+		{"pattern mismatch", `{"pattern":"^a+$"}`, `"ab"`, "$"},
+
+		{"minimum", `{"minimum":1}`, `0`, "$"},
+		{"maximum", `{"maximum":1}`, `1`, ""},
+		{"exclusive minimum", `{"exclusiveMinimum":1}`, `1`, "$"},
+		{"exclusive maximum", `{"exclusiveMaximum":1}`, `0.5`, ""},
+		{"multiple of", `{"multipleOf":0.1}`, `0.3`, ""},
+		{"multiple of mismatch", `{"multipleOf":2}`, `3`, "$"},
+
+		{"all of", `{"allOf":[{"type":"number"},{"minimum":2}]}`, `1`, "$"},
+		{"any of", `{"anyOf":[{"type":"string"},{"type":"number"}]}`, `1`, ""},
+		{"any of mismatch", `{"anyOf":[{"type":"string"},{"type":"number"}]}`, `true`, "$"},
+		{"one of", `{"oneOf":[{"type":"string"},{"type":"number"}]}`, `1`, ""},
+		{"one of twice", `{"oneOf":[{"type":"number"},{"minimum":0}]}`, `1`, "$"},
+		{"not", `{"not":{"type":"string"}}`, `"a"`, "$"},
+
+		{"ref", `{"$ref":"#/$defs/n","$defs":{"n":{"type":"number"}}}`, `"x"`, "$"},
+		{"escaped ref", `{"$ref":"#/$defs/a~1b","$defs":{"a/b":{"type":"number"}}}`, `1`, ""},
+		{"recursive ref", `{"$ref":"#/$defs/node","$defs":{"node":{"type":"object","properties":{"next":{"$ref":"#/$defs/node"},"v":{"type":"number"}}}}}`,
+			`{"v":1,"next":{"v":2,"next":{"v":"x"}}}`, "$.next.next.v"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(decode(t, tt.schema), decode(t, tt.value))
+			if tt.path == "" {
+				if err != nil {
+					t.Fatalf("Validate() = %v, want valid", err)
+				}
+				return
+			}
+			var verr *ValidationError
+			if !errors.As(err, &verr) {
+				t.Fatalf("Validate() = %v, want a validation error at %s", err, tt.path)
+			}
+			if verr.Path != tt.path {
+				t.Errorf("Validate() failed at %s, want %s: %v", verr.Path, tt.path, err)
+			}
+		})
+	}
+}
+
+func TestValidateSchemaErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		value  string
+		want   string
+	}{
+		{"self reference", `{"$ref":"#"}`, `1`, "nests too deeply"},
+		{"missing ref", `{"$ref":"#/$defs/x"}`, `1`, "not found"},
+		{"remote ref", `{"$ref":"https://example.com/s.json"}`, `1`, "only local references"},
+		{"bad pattern", `{"pattern":"("}`, `"a"`, "invalid schema pattern"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(decode(t, tt.schema), decode(t, tt.value))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Validate() = %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateBranchingRefs(t *testing.T) {
+	// Every level doubles the work, which a depth limit doesn't catch
+	schema := decode(t, `{
+		"anyOf": [{"$ref":"#/$defs/a"}, {"$ref":"#/$defs/a"}],
+		"$defs": {"a": {"anyOf": [{"$ref":"#/$defs/a"}, {"$ref":"#/$defs/a"}]}}
+	}`)
+	if err := Check(schema); err != nil {
+		t.Fatalf("Check() = %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- Validate(schema, 1.0) }()
+	select {
+	case err := <-done:
+		if !errors.Is(err, errTooComplex) {
+			t.Errorf("Validate() = %v, want %v", err, errTooComplex)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Validate() did not stop")
+	}
+}
+
+func TestValidateBudgetNotTakenForMismatch(t *testing.T) {
+	// An exhausted budget under "not" must not count as a mismatch that
+	// lets the value pass
+	schema := decode(t, `{
+		"not": {"$ref":"#/$defs/a"},
+		"$defs": {"a": {"anyOf": [{"$ref":"#/$defs/a"}, {"$ref":"#/$defs/a"}]}}
+	}`)
+	if err := Validate(schema, 1.0); !errors.Is(err, errTooComplex) {
+		t.Errorf("Validate() = %v, want %v", err, errTooComplex)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		want   string
+	}{
+		{"valid", `{"type":"object","properties":{"a":{"type":["string","null"]}},"required":["a"]}`, ""},
+		{"boolean", `false`, ""},
+		{"recursive ref", `{"$defs":{"n":{"items":{"$ref":"#/$defs/n"}}},"$ref":"#/$defs/n"}`, ""},
+		{"not an object", `[1]`, "must be an object or boolean"},
+		{"nested not an object", `{"properties":{"a":1}}`, "invalid schema at $/properties/a"},
+		{"unknown type", `{"type":"text"}`, "invalid schema type"},
+		{"empty type list", `{"type":[]}`, "invalid schema type"},
+		{"bad pattern", `{"items":{"pattern":"["}}`, "invalid schema pattern"},
+		{"missing ref", `{"anyOf":[{"$ref":"#/$defs/x"}]}`, "not found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Check(decode(t, tt.schema))
+			switch {
+			case tt.want == "" && err != nil:
+				t.Errorf("Check() = %v, want nil", err)
+			case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+				t.Errorf("Check() = %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package openai
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// MessageContent holds message content, which may be sent either as a plain
+// string or as an array of content parts
+type MessageContent struct {
+	text  string
+	Parts []ContentPart
+}
+
+// TextContent creates plain string content
+func TextContent(text string) MessageContent {
+	return MessageContent{text: text}
+}
+
+// String returns the text of the content, joining text parts if needed
+func (c MessageContent) String() string {
+	if c.Parts == nil {
+		return c.text
+	}
+	var texts []string
+	for _, part := range c.Parts {
+		if part.Type == "text" {
+			texts = append(texts, part.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// IsEmpty reports whether the content has no text and no parts
+func (c MessageContent) IsEmpty() bool {
+	return c.text == "" && len(c.Parts) == 0
+}
+
+// MarshalJSON encodes the content as a string, an array of parts, or null
+func (c MessageContent) MarshalJSON() ([]byte, error) {
+	if c.Parts != nil {
+		return json.Marshal(c.Parts)
+	}
+	if c.text == "" {
+		return []byte("null"), nil
+	}
+	return json.Marshal(c.text)
+}
+
+// UnmarshalJSON accepts a string, an array of content parts, or null
+func (c *MessageContent) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	switch {
+	case bytes.Equal(data, []byte("null")):
+		*c = MessageContent{}
+		return nil
+	case len(data) > 0 && data[0] == '"':
+		*c = MessageContent{}
+		return json.Unmarshal(data, &c.text)
+	case len(data) > 0 && data[0] == '[':
+		var parts []ContentPart
+		if err := json.Unmarshal(data, &parts); err != nil {
+			return err
+		}
+		*c = MessageContent{Parts: parts}
+		return nil
+	default:
+		return fmt.Errorf("content must be a string or an array of content parts")
+	}
+}
+
+// ContentPart represents one part of a multimodal message
+type ContentPart struct {
+	Type       string      `json:"type"`
+	Text       string      `json:"text,omitempty"`
+	ImageURL   *ImageURL   `json:"image_url,omitempty"`
+	File       *File       `json:"file,omitempty"`
+	InputAudio *InputAudio `json:"input_audio,omitempty"`
+}
+
+// ImageURL references an image by URL or base64 data URL
+type ImageURL struct {
+	URL    string `json:"url"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// File carries file content inline or by reference
+type File struct {
+	FileID   string `json:"file_id,omitempty"`
+	FileData string `json:"file_data,omitempty"`
+	Filename string `json:"filename,omitempty"`
+}
+
+// InputAudio carries base64-encoded audio
+type InputAudio struct {
+	Data   string `json:"data"`
+	Format string `json:"format"`
+}
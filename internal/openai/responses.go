@@ -0,0 +1,154 @@
+package openai
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// ResponseRequest represents a Responses API create request
+type ResponseRequest struct {
+	Model              string            `json:"model"`
+	Input              ResponseInput     `json:"input"`
+	Instructions       string            `json:"instructions,omitempty"`
+	PreviousResponseID string            `json:"previous_response_id,omitempty"`
+	Stream             bool              `json:"stream,omitempty"`
+	Store              *bool             `json:"store,omitempty"`
+	MaxOutputTokens    int               `json:"max_output_tokens,omitempty"`
+	Temperature        float64           `json:"temperature,omitempty"`
+	TopP               float64           `json:"top_p,omitempty"`
+	Tools              []any             `json:"tools,omitempty"`
+	Metadata           map[string]string `json:"metadata,omitempty"`
+	User               string            `json:"user,omitempty"`
+}
+
+// ResponseInput is either a plain string or a list of input items
+type ResponseInput struct {
+	Text  string
+	Items []ResponseInputItem
+}
+
+// UnmarshalJSON accepts a string or an array of input items
+func (in *ResponseInput) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	switch {
+	case len(data) > 0 && data[0] == '"':
+		*in = ResponseInput{}
+		return json.Unmarshal(data, &in.Text)
+	case len(data) > 0 && data[0] == '[':
+		*in = ResponseInput{}
+		return json.Unmarshal(data, &in.Items)
+	default:
+		return fmt.Errorf("input must be a string or an array of input items")
+	}
+}
+
+// ResponseInputItem represents a message in the Responses API input
+type ResponseInputItem struct {
+	Type    string          `json:"type,omitempty"`
+	Role    string          `json:"role,omitempty"`
+	Content ResponseContent `json:"content"`
+}
+
+// ResponseContent is either a plain string or a list of content parts
+type ResponseContent struct {
+	Text  string
+	Parts []ResponseContentPart
+}
+
+// UnmarshalJSON accepts a string or an array of content parts
+func (c *ResponseContent) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	switch {
+	case len(data) > 0 && data[0] == '"':
+		*c = ResponseContent{}
+		return json.Unmarshal(data, &c.Text)
+	case len(data) > 0 && data[0] == '[':
+		*c = ResponseContent{}
+		return json.Unmarshal(data, &c.Parts)
+	default:
+		return fmt.Errorf("content must be a string or an array of content parts")
+	}
+}
+
+// ResponseContentPart represents an input or output content part
+type ResponseContentPart struct {
+	Type     string `json:"type"`
+	Text     string `json:"text,omitempty"`
+	ImageURL string `json:"image_url,omitempty"`
+	Detail   string `json:"detail,omitempty"`
+	FileID   string `json:"file_id,omitempty"`
+	FileData string `json:"file_data,omitempty"`
+	Filename string `json:"filename,omitempty"`
+}
+
+// Response represents a Responses API response object
+type Response struct {
+	ID                 string               `json:"id"`
+	Object             string               `json:"object"`
+	CreatedAt          int64                `json:"created_at"`
+	Status             string               `json:"status"`
+	Model              string               `json:"model"`
+	Output             []ResponseOutputItem `json:"output"`
+	PreviousResponseID *string              `json:"previous_response_id"`
+	Error              *ResponseError       `json:"error"`
+	Usage              *ResponseUsage       `json:"usage,omitempty"`
+	Metadata           map[string]string    `json:"metadata,omitempty"`
+}
+
+// ResponseOutputItem represents an item in a response's output
+type ResponseOutputItem struct {
+	Type    string                  `json:"type"`
+	ID      string                  `json:"id"`
+	Status  string                  `json:"status"`
+	Role    string                  `json:"role"`
+	Content []ResponseOutputContent `json:"content"`
+}
+
+// ResponseOutputContent represents a content part of an output message
+type ResponseOutputContent struct {
+	Type        string `json:"type"`
+	Text        string `json:"text"`
+	Annotations []any  `json:"annotations"`
+}
+
+// ResponseUsage represents token usage in the Responses API
+type ResponseUsage struct {
+	InputTokens         int                  `json:"input_tokens"`
+	InputTokensDetails  PromptTokensDetails  `json:"input_tokens_details"`
+	OutputTokens        int                  `json:"output_tokens"`
+	OutputTokensDetails ResponseOutputDetail `json:"output_tokens_details"`
+	TotalTokens         int                  `json:"total_tokens"`
+}
+
+// ResponseOutputDetail breaks down output token usage in the Responses API
+type ResponseOutputDetail struct {
+	ReasoningTokens int `json:"reasoning_tokens"`
+}
+
+// ResponseError describes why a response failed
+type ResponseError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ResponseStreamEvent represents a typed Responses API streaming event
+type ResponseStreamEvent struct {
+	Type           string                 `json:"type"`
+	SequenceNumber int                    `json:"sequence_number"`
+	Response       *Response              `json:"response,omitempty"`
+	OutputIndex    *int                   `json:"output_index,omitempty"`
+	ContentIndex   *int                   `json:"content_index,omitempty"`
+	ItemID         string                 `json:"item_id,omitempty"`
+	Item           *ResponseOutputItem    `json:"item,omitempty"`
+	Part           *ResponseOutputContent `json:"part,omitempty"`
+	Delta          string                 `json:"delta,omitempty"`
+	Text           *string                `json:"text,omitempty"`
+}
+
+// DeletedResponse is returned when a stored response is deleted
+type DeletedResponse struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Deleted bool   `json:"deleted"`
+}
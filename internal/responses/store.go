@@ -0,0 +1,78 @@
+package responses
+
+import (
+	"container/list"
+	"sync"
+
+	"claude-cli-as-openai-api/internal/openai"
+)
+
+// Store keeps recent Responses API objects in memory together with the CLI
+// session that produced them, so follow-ups can resume that session.
+// Responses belong to the API key that created them and can't be seen by
+// others. The oldest responses are evicted once the store is full.
+type Store struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type entry struct {
+	owner     string
+	response  *openai.Response
+	sessionID string
+}
+
+// NewStore creates a store holding at most size responses
+func NewStore(size int) *Store {
+	return &Store{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// Put stores a response of owner and the session ID it belongs to
+func (s *Store) Put(owner string, response *openai.Response, sessionID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if elem, ok := s.entries[response.ID]; ok {
+		s.order.Remove(elem)
+	}
+	s.entries[response.ID] = s.order.PushBack(&entry{owner: owner, response: response, sessionID: sessionID})
+
+	for s.order.Len() > s.size {
+		oldest := s.order.Front()
+		s.order.Remove(oldest)
+		delete(s.entries, oldest.Value.(*entry).response.ID)
+	}
+}
+
+// Get returns a stored response of owner and its session ID
+func (s *Store) Get(owner, id string) (*openai.Response, string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	elem, ok := s.entries[id]
+	if !ok || elem.Value.(*entry).owner != owner {
+		return nil, "", false
+	}
+	e := elem.Value.(*entry)
+	return e.response, e.sessionID, true
+}
+
+// Delete removes a stored response of owner, reporting whether it existed
+func (s *Store) Delete(owner, id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	elem, ok := s.entries[id]
+	if !ok || elem.Value.(*entry).owner != owner {
+		return false
+	}
+	s.order.Remove(elem)
+	delete(s.entries, id)
+	return true
+}
@@ -0,0 +1,202 @@
+package scheduler
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// QueueFullError reports a request turned away because the wait queue, or
+// its key's share of it, is full
+type QueueFullError struct {
+	// Depth is the number of requests waiting
+	Depth int
+
+	// RetryAfter estimates when a slot will be free
+	RetryAfter time.Duration
+}
+
+func (e *QueueFullError) Error() string {
+	return "too many requests are waiting for the Claude CLI; try again later"
+}
+
+// minRetryAfter is the shortest wait suggested to a rejected request
+const minRetryAfter = time.Second
+
+// Scheduler bounds the number of CLI runs at once. Requests that can't run
+// yet wait in a bounded queue, where the keys of different clients take
+// turns so a burst from one doesn't starve the others.
+type Scheduler struct {
+	max    int
+	size   int
+	perKey int
+
+	mu      sync.Mutex
+	running int
+	queued  int
+	// keys lists the keys with waiting requests in the order they are
+	// served, and waiters holds each key's requests in arrival order
+	keys    []string
+	waiters map[string][]*waiter
+
+	// hold is a moving average of how long requests hold their runs
+	hold time.Duration
+}
+
+type waiter struct {
+	key      string
+	runs     int
+	admitted chan struct{}
+}
+
+// New creates a scheduler for max runs at once, queueing at most size
+// requests and perKey requests of one key
+func New(max, size, perKey int) *Scheduler {
+	return &Scheduler{
+		max:     max,
+		size:    size,
+		perKey:  perKey,
+		waiters: make(map[string][]*waiter),
+	}
+}
+
+// Ticket is a request admitted to run
+type Ticket struct {
+	// Depth is the number of requests that were waiting when it arrived
+	Depth int
+
+	// Wait is how long it waited to be admitted
+	Wait time.Duration
+
+	s     *Scheduler
+	runs  int
+	start time.Time
+	once  sync.Once
+}
+
+// Acquire waits until a request of key may start runs CLI processes at
+// once. It fails with a QueueFullError if the request can't be queued, or
+// with the context's error if the client goes away first.
+func (s *Scheduler) Acquire(ctx context.Context, key string, runs int) (*Ticket, error) {
+	runs = min(max(runs, 1), s.max)
+	arrived := time.Now()
+
+	s.mu.Lock()
+	depth := s.queued
+	if depth == 0 && s.running+runs <= s.max {
+		s.running += runs
+		s.mu.Unlock()
+		return s.ticket(runs, 0, arrived), nil
+	}
+	if s.queued >= s.size || len(s.waiters[key]) >= s.perKey {
+		err := &QueueFullError{Depth: depth, RetryAfter: s.retryAfter()}
+		s.mu.Unlock()
+		return nil, err
+	}
+
+	w := &waiter{key: key, runs: runs, admitted: make(chan struct{})}
+	if len(s.waiters[key]) == 0 {
+		s.keys = append(s.keys, key)
+	}
+	s.waiters[key] = append(s.waiters[key], w)
+	s.queued++
+	s.mu.Unlock()
+
+	select {
+	case <-w.admitted:
+		return s.ticket(runs, depth, arrived), nil
+	case <-ctx.Done():
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-w.admitted:
+		// Admitted while giving up; hand the runs on
+		s.running -= runs
+		s.dispatch()
+	default:
+		s.remove(w)
+	}
+	return nil, ctx.Err()
+}
+
+func (s *Scheduler) ticket(runs, depth int, arrived time.Time) *Ticket {
+	now := time.Now()
+	return &Ticket{Depth: depth, Wait: now.Sub(arrived), s: s, runs: runs, start: now}
+}
+
+// Release frees the ticket's runs for waiting requests
+func (t *Ticket) Release() {
+	t.once.Do(func() {
+		s := t.s
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		held := time.Since(t.start)
+		if s.hold == 0 {
+			s.hold = held
+		} else {
+			s.hold += (held - s.hold) / 8
+		}
+		s.running -= t.runs
+		s.dispatch()
+	})
+}
+
+// dispatch admits waiting requests while runs are free, taking one
+// request from each key in turn. The caller holds s.mu.
+func (s *Scheduler) dispatch() {
+	for len(s.keys) > 0 {
+		key := s.keys[0]
+		w := s.waiters[key][0]
+		if s.running+w.runs > s.max {
+			return
+		}
+
+		s.running += w.runs
+		s.queued--
+		s.waiters[key] = s.waiters[key][1:]
+		s.keys = s.keys[1:]
+		if len(s.waiters[key]) > 0 {
+			s.keys = append(s.keys, key)
+		} else {
+			delete(s.waiters, key)
+		}
+		close(w.admitted)
+	}
+}
+
+// remove takes a request that gave up out of the queue. The caller holds
+// s.mu.
+func (s *Scheduler) remove(w *waiter) {
+	queue := s.waiters[w.key]
+	for i, other := range queue {
+		if other == w {
+			queue = append(queue[:i], queue[i+1:]...)
+			break
+		}
+	}
+	s.queued--
+	if len(queue) > 0 {
+		s.waiters[w.key] = queue
+	} else {
+		delete(s.waiters, w.key)
+		for i, key := range s.keys {
+			if key == w.key {
+				s.keys = append(s.keys[:i], s.keys[i+1:]...)
+				break
+			}
+		}
+	}
+	// The request may have been holding up the ones behind it
+	s.dispatch()
+}
+
+// retryAfter estimates when a rejected request could be admitted, from
+// how long requests hold their runs and how many are waiting. The caller
+// holds s.mu.
+func (s *Scheduler) retryAfter() time.Duration {
+	wait := s.hold * time.Duration(s.queued/s.max+1)
+	return max(wait.Round(time.Second), minRetryAfter)
+}
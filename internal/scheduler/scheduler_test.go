@@ -0,0 +1,193 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// waitQueued waits until n requests are queued on s
+func waitQueued(t *testing.T, s *Scheduler, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s.mu.Lock()
+		queued := s.queued
+		s.mu.Unlock()
+		if queued == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d requests queued, want %d", queued, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// admitted is a request that got its ticket
+type admitted struct {
+	name   string
+	ticket *Ticket
+}
+
+// acquireAsync queues a request and reports its ticket on done
+func acquireAsync(t *testing.T, s *Scheduler, key, name string, runs int, done chan<- admitted) {
+	t.Helper()
+	go func() {
+		ticket, err := s.Acquire(context.Background(), key, runs)
+		if err != nil {
+			t.Errorf("Acquire(%s) = %v", name, err)
+			return
+		}
+		done <- admitted{name, ticket}
+	}()
+}
+
+func receive(t *testing.T, done <-chan admitted) admitted {
+	t.Helper()
+	select {
+	case a := <-done:
+		return a
+	case <-time.After(5 * time.Second):
+		t.Fatal("no request was admitted")
+		return admitted{}
+	}
+}
+
+func TestAcquireWithinLimit(t *testing.T) {
+	s := New(2, 10, 10)
+	first, err := s.Acquire(context.Background(), "a", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Acquire(context.Background(), "a", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan admitted, 1)
+	acquireAsync(t, s, "a", "third", 1, done)
+	waitQueued(t, s, 1)
+	select {
+	case <-done:
+		t.Fatal("a third run started while two were running")
+	default:
+	}
+
+	first.Release()
+	if a := receive(t, done); a.ticket.Depth != 0 {
+		t.Errorf("Depth = %d, want 0", a.ticket.Depth)
+	}
+
+	// Releasing twice frees the runs once
+	first.Release()
+	s.mu.Lock()
+	running := s.running
+	s.mu.Unlock()
+	if running != 2 {
+		t.Errorf("running = %d, want 2", running)
+	}
+}
+
+func TestAcquireSeveralRuns(t *testing.T) {
+	s := New(3, 10, 10)
+	held, err := s.Acquire(context.Background(), "a", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan admitted, 1)
+	acquireAsync(t, s, "b", "pair", 2, done)
+	waitQueued(t, s, 1)
+
+	held.Release()
+	receive(t, done)
+}
+
+func TestKeysTakeTurns(t *testing.T) {
+	s := New(1, 10, 10)
+	held, err := s.Acquire(context.Background(), "a", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan admitted)
+	for i, name := range []string{"a1", "a2", "a3"} {
+		acquireAsync(t, s, "a", name, 1, done)
+		waitQueued(t, s, i+1)
+	}
+	acquireAsync(t, s, "b", "b1", 1, done)
+	waitQueued(t, s, 4)
+
+	held.Release()
+	var order []string
+	for range 4 {
+		a := receive(t, done)
+		order = append(order, a.name)
+		a.ticket.Release()
+	}
+	want := []string{"a1", "b1", "a2", "a3"}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("admitted %v, want %v", order, want)
+		}
+	}
+}
+
+func TestQueueFull(t *testing.T) {
+	s := New(1, 2, 1)
+	if _, err := s.Acquire(context.Background(), "a", 1); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan admitted, 2)
+	acquireAsync(t, s, "a", "a1", 1, done)
+	waitQueued(t, s, 1)
+
+	// The key's share of the queue is full
+	_, err := s.Acquire(context.Background(), "a", 1)
+	var full *QueueFullError
+	if !errors.As(err, &full) {
+		t.Fatalf("Acquire() = %v, want a QueueFullError", err)
+	}
+	if full.Depth != 1 || full.RetryAfter < minRetryAfter {
+		t.Errorf("QueueFullError = %+v, want depth 1 and a retry hint", full)
+	}
+
+	acquireAsync(t, s, "b", "b1", 1, done)
+	waitQueued(t, s, 2)
+
+	// The whole queue is full
+	if _, err := s.Acquire(context.Background(), "c", 1); !errors.As(err, &full) {
+		t.Errorf("Acquire() = %v, want a QueueFullError", err)
+	}
+}
+
+func TestAcquireCanceled(t *testing.T) {
+	s := New(2, 10, 10)
+	held, err := s.Acquire(context.Background(), "a", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A request for both runs waits at the head of the queue, holding up
+	// a smaller one behind it until it gives up
+	ctx, cancel := context.WithCancel(context.Background())
+	canceled := make(chan error, 1)
+	go func() {
+		_, err := s.Acquire(ctx, "a", 2)
+		canceled <- err
+	}()
+	waitQueued(t, s, 1)
+
+	done := make(chan admitted, 1)
+	acquireAsync(t, s, "b", "b1", 1, done)
+	waitQueued(t, s, 2)
+
+	cancel()
+	if err := <-canceled; !errors.Is(err, context.Canceled) {
+		t.Errorf("Acquire() = %v, want %v", err, context.Canceled)
+	}
+	receive(t, done)
+	held.Release()
+	waitQueued(t, s, 0)
+}
@@ -0,0 +1,170 @@
+package session
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"claude-cli-as-openai-api/internal/openai"
+)
+
+// Cache maps fingerprints of conversation prefixes to the CLI sessions that
+// already hold them. When a request extends a conversation the cache has
+// seen, the CLI can resume that session and only the new turns are sent.
+type Cache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type entry struct {
+	fingerprint string
+	sessionID   string
+	expires     time.Time
+}
+
+// NewCache creates a cache holding at most size sessions, each for ttl
+// after it was last used
+func NewCache(size int, ttl time.Duration) *Cache {
+	return &Cache{
+		size:    size,
+		ttl:     ttl,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// Lookup finds the longest prefix of messages the client has sent before.
+// It returns the session holding that prefix and the messages that follow
+// it. The session must be resumed as a fork, so it keeps holding only the
+// prefix for later branches of the conversation.
+func (c *Cache) Lookup(client, model string, messages []openai.Message) (string, []openai.Message, bool) {
+	prints := fingerprints(client, model, messages)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	// The last message is always new, so the prefix must be shorter
+	for i := len(messages) - 2; i >= 0; i-- {
+		elem, ok := c.entries[prints[i]]
+		if !ok {
+			continue
+		}
+		e := elem.Value.(*entry)
+		if now.After(e.expires) {
+			c.remove(elem)
+			continue
+		}
+		e.expires = now.Add(c.ttl)
+		c.order.MoveToBack(elem)
+		return e.sessionID, messages[i+1:], true
+	}
+	return "", nil, false
+}
+
+// Store records that a session holds messages of the client followed by
+// reply
+func (c *Cache) Store(client, model string, messages []openai.Message, reply openai.Message, sessionID string) {
+	if sessionID == "" {
+		return
+	}
+	full := append(append([]openai.Message(nil), messages...), reply)
+	prints := fingerprints(client, model, full)
+	key := prints[len(prints)-1]
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+	c.entries[key] = c.order.PushBack(&entry{
+		fingerprint: key,
+		sessionID:   sessionID,
+		expires:     time.Now().Add(c.ttl),
+	})
+
+	for c.order.Len() > c.size {
+		c.remove(c.order.Front())
+	}
+}
+
+// Forget drops every prefix held by a session, for example after the CLI
+// failed to resume it
+func (c *Cache) Forget(sessionID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for elem := c.order.Front(); elem != nil; {
+		next := elem.Next()
+		if elem.Value.(*entry).sessionID == sessionID {
+			c.remove(elem)
+		}
+		elem = next
+	}
+}
+
+func (c *Cache) remove(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.entries, elem.Value.(*entry).fingerprint)
+}
+
+// fingerprints returns a chained hash for every prefix of messages, so
+// prints[i] identifies messages[:i+1] of the client under the given model
+func fingerprints(client, model string, messages []openai.Message) []string {
+	prints := make([]string, len(messages))
+	prev := sha256.Sum256([]byte(client + "\x00" + model))
+	for i, msg := range messages {
+		h := sha256.New()
+		h.Write(prev[:])
+		h.Write(canonical(msg))
+		copy(prev[:], h.Sum(nil))
+		prints[i] = hex.EncodeToString(prev[:])
+	}
+	return prints
+}
+
+// canonical encodes the parts of a message that identify it, normalizing
+// whitespace clients commonly trim when echoing assistant replies
+func canonical(msg openai.Message) []byte {
+	type call struct {
+		ID        string `json:"id"`
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	}
+	form := struct {
+		Role       string          `json:"role"`
+		Name       string          `json:"name,omitempty"`
+		Text       string          `json:"text,omitempty"`
+		Parts      json.RawMessage `json:"parts,omitempty"`
+		ToolCalls  []call          `json:"tool_calls,omitempty"`
+		ToolCallID string          `json:"tool_call_id,omitempty"`
+	}{
+		Role:       msg.Role,
+		Name:       msg.Name,
+		ToolCallID: msg.ToolCallID,
+	}
+
+	if msg.Content.Parts != nil {
+		form.Parts, _ = json.Marshal(msg.Content.Parts)
+	} else {
+		form.Text = strings.TrimSpace(msg.Content.String())
+	}
+	for _, tc := range msg.ToolCalls {
+		form.ToolCalls = append(form.ToolCalls, call{
+			ID:        tc.ID,
+			Name:      tc.Function.Name,
+			Arguments: strings.TrimSpace(tc.Function.Arguments),
+		})
+	}
+
+	data, _ := json.Marshal(form)
+	return data
+}
@@ -0,0 +1,264 @@
+package workspace
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Mode selects how long a workspace's files live
+type Mode string
+
+const (
+	// ModeShared runs every request in the server's working directory
+	ModeShared Mode = "shared"
+	// ModeRequest empties a workspace as soon as its request finishes.
+	// The path is kept, so the conversation's CLI sessions stay resumable.
+	ModeRequest Mode = "request"
+	// ModeSession keeps a workspace's files for as long as a session of
+	// its conversation may be resumed
+	ModeSession Mode = "session"
+)
+
+// ErrNotAllowed reports a project directory a request may not run in
+var ErrNotAllowed = errors.New("workspace directory not allowed")
+
+// ParseMode validates a workspace mode
+func ParseMode(s string) (Mode, error) {
+	switch m := Mode(s); m {
+	case ModeShared, ModeRequest, ModeSession:
+		return m, nil
+	}
+	return "", fmt.Errorf("invalid workspace mode %q: must be shared, request or session", s)
+}
+
+// Manager hands out isolated working directories for CLI runs. The CLI
+// stores sessions per working directory, so a workspace is tracked by the
+// sessions created in it and reused when one of them is resumed.
+type Manager struct {
+	root    string
+	mode    Mode
+	ttl     time.Duration
+	allowed []string
+
+	mu       sync.Mutex
+	sessions map[string]*space
+
+	// pool prepares warm CLI processes in the spare workspaces, which are
+	// handed to new conversations first
+	pool   Pool
+	spares []string
+}
+
+// Pool is a pool of warm CLI processes that follows the workspaces
+type Pool interface {
+	// Prewarm prepares a process in the spare workspace dir
+	Prewarm(dir string)
+	// Discard stops the idle processes in a workspace being removed
+	Discard(dir string)
+}
+
+// space is a workspace directory shared by the sessions of a conversation
+type space struct {
+	dir      string
+	active   int
+	lastUsed time.Time
+}
+
+// NewManager creates workspaces under root, keeping them for ttl after
+// they were last used. allowed lists the project directories requests may
+// choose to run in instead. Workspaces left under root by an earlier run
+// are removed, since their sessions are no longer known.
+func NewManager(root string, mode Mode, ttl time.Duration, allowed []string) *Manager {
+	m := &Manager{
+		root:     root,
+		mode:     mode,
+		ttl:      ttl,
+		allowed:  allowed,
+		sessions: make(map[string]*space),
+	}
+	if mode != ModeShared {
+		stale, _ := filepath.Glob(filepath.Join(root, "ws_*"))
+		for _, dir := range stale {
+			m.remove(dir)
+		}
+	}
+	return m
+}
+
+// SetPool keeps n spare workspaces with warm processes prepared by pool
+func (m *Manager) SetPool(pool Pool, n int) {
+	if m.mode == ModeShared {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pool = pool
+	for range n {
+		m.addSpare()
+	}
+}
+
+// Workspace is the working directory of one request
+type Workspace struct {
+	// Dir is the directory to run the CLI in; empty uses the server's
+	Dir string
+
+	m     *Manager
+	space *space
+}
+
+// Acquire returns the workspace for a request. A request resuming a
+// session runs where that session was created; others get a new
+// workspace.
+func (m *Manager) Acquire(sessionID string) (*Workspace, error) {
+	if m.mode == ModeShared {
+		return &Workspace{m: m}, nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sweep()
+
+	s, ok := m.sessions[sessionID]
+	if !ok {
+		s = &space{dir: m.newDir()}
+	}
+	// A request-mode workspace was removed after its last request
+	if err := os.MkdirAll(s.dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create workspace: %w", err)
+	}
+	s.active++
+	s.lastUsed = time.Now()
+	return &Workspace{Dir: s.dir, m: m, space: s}, nil
+}
+
+// Project returns a workspace in a project directory chosen by the
+// request, which must be one of the allowed directories or inside one.
+// A non-nil limit, such as the projects of the client's API key, must also
+// hold the directory. Project directories are never cleaned up.
+func (m *Manager) Project(dir string, limit []string) (*Workspace, error) {
+	dir = filepath.Clean(dir)
+	if !filepath.IsAbs(dir) {
+		return nil, fmt.Errorf("%w: %s is not an absolute path", ErrNotAllowed, dir)
+	}
+	if !within(dir, m.allowed) || (limit != nil && !within(dir, limit)) {
+		return nil, fmt.Errorf("%w: %s", ErrNotAllowed, dir)
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("%w: %s is not a directory", ErrNotAllowed, dir)
+	}
+	return &Workspace{Dir: dir, m: m}, nil
+}
+
+// within reports whether dir is one of roots or inside one
+func within(dir string, roots []string) bool {
+	for _, root := range roots {
+		rel, err := filepath.Rel(root, dir)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Static returns a workspace in a fixed directory, such as a model's
+// project directory. It is never cleaned up.
+func Static(dir string) *Workspace {
+	return &Workspace{Dir: dir}
+}
+
+// Bind records that the CLI created or continued a session in the
+// workspace, so a request resuming it runs there again
+func (w *Workspace) Bind(sessionID string) {
+	if w.space == nil || sessionID == "" {
+		return
+	}
+	w.m.mu.Lock()
+	defer w.m.mu.Unlock()
+	w.m.sessions[sessionID] = w.space
+}
+
+// Release ends the request's use of the workspace. In request mode its
+// files are removed right away.
+func (w *Workspace) Release() {
+	if w.space == nil {
+		return
+	}
+	w.m.mu.Lock()
+	defer w.m.mu.Unlock()
+
+	w.space.active--
+	w.space.lastUsed = time.Now()
+	if w.space.active == 0 && (w.m.mode == ModeRequest || !w.m.bound(w.space)) {
+		w.m.remove(w.space.dir)
+	}
+	w.space = nil
+}
+
+// sweep removes workspaces whose sessions haven't been used for the TTL
+func (m *Manager) sweep() {
+	now := time.Now()
+	for id, s := range m.sessions {
+		if s.active == 0 && now.Sub(s.lastUsed) > m.ttl {
+			delete(m.sessions, id)
+			m.remove(s.dir)
+		}
+	}
+}
+
+// bound reports whether any session is recorded in s
+func (m *Manager) bound(s *space) bool {
+	for _, other := range m.sessions {
+		if other == s {
+			return true
+		}
+	}
+	return false
+}
+
+// newDir returns the directory of a new workspace, taking a spare one if
+// there is any. The caller holds m.mu.
+func (m *Manager) newDir() string {
+	if len(m.spares) == 0 {
+		return filepath.Join(m.root, "ws_"+randomID())
+	}
+	dir := m.spares[0]
+	m.spares = m.spares[1:]
+	m.addSpare()
+	return dir
+}
+
+// addSpare creates a spare workspace and has the pool prepare it. The
+// caller holds m.mu.
+func (m *Manager) addSpare() {
+	dir := filepath.Join(m.root, "ws_"+randomID())
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		log.Printf("Failed to create spare workspace: %v", err)
+		return
+	}
+	m.spares = append(m.spares, dir)
+	m.pool.Prewarm(dir)
+}
+
+func randomID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (m *Manager) remove(dir string) {
+	if m.pool != nil {
+		m.pool.Discard(dir)
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		log.Printf("Failed to remove workspace %s: %v", dir, err)
+	}
+}
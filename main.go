@@ -10,10 +10,18 @@ import (
 )
 
 func main() {
-	cfg := config.Load()
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+
+	executor := claude.NewExecutor(cfg.ClaudePath, cfg.KillGrace, cfg.Pool)
+	metrics := &claude.Metrics{}
+	backend := claude.Wrap(executor, claude.Retry(cfg.Retry), claude.Measure(metrics))
 
-	executor := claude.NewExecutor(cfg.ClaudePath)
-	handlers := api.NewHandlers(executor)
+	handlers := api.NewHandlers(backend, cfg)
+	handlers.SetPool(executor)
+	handlers.SetMetrics(metrics)
 	router := api.NewRouter(handlers)
 
 	addr := ":" + cfg.Port
@@ -2,14 +2,19 @@ package sse
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 )
 
+// ErrClosed is returned when writing to a stream that ended with an error
+var ErrClosed = errors.New("sse: stream closed")
+
 // Writer handles Server-Sent Events writing
 type Writer struct {
 	w       http.ResponseWriter
 	flusher http.Flusher
+	closed  bool
 }
 
 // NewWriter creates a new SSE writer
@@ -33,6 +38,9 @@ func NewWriter(w http.ResponseWriter) (*Writer, error) {
 
 // WriteEvent writes a data event
 func (w *Writer) WriteEvent(data any) error {
+	if w.closed {
+		return ErrClosed
+	}
 	jsonData, err := json.Marshal(data)
 	if err != nil {
 		return err
@@ -47,8 +55,48 @@ func (w *Writer) WriteEvent(data any) error {
 	return nil
 }
 
+// WriteNamedEvent writes a data event with an event name, as used by the
+// Anthropic and OpenAI Responses streaming APIs
+func (w *Writer) WriteNamedEvent(event string, data any) error {
+	if w.closed {
+		return ErrClosed
+	}
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w.w, "event: %s\ndata: %s\n\n", event, jsonData)
+	if err != nil {
+		return err
+	}
+
+	w.flusher.Flush()
+	return nil
+}
+
+// WriteError writes an error as the last data event of the stream, in the
+// OpenAI style of a data frame holding an error object. Later writes,
+// including [DONE], fail with ErrClosed.
+func (w *Writer) WriteError(data any) error {
+	err := w.WriteEvent(data)
+	w.closed = true
+	return err
+}
+
+// WriteNamedError is WriteError for streams with named events, such as
+// the Anthropic "error" event
+func (w *Writer) WriteNamedError(event string, data any) error {
+	err := w.WriteNamedEvent(event, data)
+	w.closed = true
+	return err
+}
+
 // WriteDone writes the final [DONE] event
 func (w *Writer) WriteDone() error {
+	if w.closed {
+		return ErrClosed
+	}
 	_, err := fmt.Fprint(w.w, "data: [DONE]\n\n")
 	if err != nil {
 		return err